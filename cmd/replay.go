@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/labbsr0x/kafka2influxdb/database"
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+	"github.com/labbsr0x/kafka2influxdb/web/controllers"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// replayCmd represents the replay-dlq command
+var replayCmd = &cobra.Command{
+	Use:   "replay-dlq",
+	Short: "Replays the messages of the dead letter topic through the persisting pipeline",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return viper.GetViper().BindPFlags(cmd.Flags())
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		builder := new(config.WebBuilder).Init(viper.GetViper())
+		consumer := controllers.NewConsumerController(builder)
+		defer consumer.Close()
+		kafka := database.NewKafka(builder).Connect()
+		defer kafka.Close()
+
+		return kafka.ReplayDeadLetters(consumer.ListenHandler)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	config.AddFlags(replayCmd.Flags())
+}
@@ -11,11 +11,15 @@ import (
 var serveCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "Starts the HTTP REST APIs server",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return viper.GetViper().BindPFlags(cmd.Flags())
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		builder := new(config.WebBuilder).Init(viper.GetViper())
 		server := new(web.Server).InitFromWebBuilder(builder)
-		server.Run()
-		return nil
+		// the usage doesn't help once the server is running
+		cmd.SilenceUsage = true
+		return server.Run()
 	},
 }
 
@@ -23,9 +27,4 @@ func init() {
 	rootCmd.AddCommand(serveCmd)
 
 	config.AddFlags(serveCmd.Flags())
-
-	err := viper.GetViper().BindPFlags(serveCmd.Flags())
-	if err != nil {
-		panic(err)
-	}
 }
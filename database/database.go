@@ -1,26 +1,41 @@
 package database
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
+	"github.com/labbsr0x/kafka2influxdb/database/influxql"
 	"github.com/labbsr0x/kafka2influxdb/database/models"
 	"github.com/labbsr0x/kafka2influxdb/web/config"
+	"github.com/labbsr0x/kafka2influxdb/web/metrics"
 
-	"github.com/huandu/go-sqlbuilder"
 	_ "github.com/influxdata/influxdb1-client" // this is important because of the bug in go mod
+	influxmodels "github.com/influxdata/influxdb1-client/models"
 	client "github.com/influxdata/influxdb1-client/v2"
 	"github.com/sirupsen/logrus"
 )
 
+// exportChunkSize is the number of points InfluxDB sends at a time in exports
+const exportChunkSize = 10000
+
 // Database defines the methods that can be performed
 type Database interface {
 	Init(webBuilder *config.WebBuilder) Database
 	Connect() Database
 	Close() error
+	Ping(timeout time.Duration) error
 	GetPoints(data *models.Data) ([]models.StatePoint, error)
+	GetLatestPoints(data *models.Data) ([]models.StatePoint, error)
+	ExportPoints(data *models.Data, handler func(point models.StatePoint) error) error
+	FieldKeys() (map[string]string, error)
+	CountPoints(data *models.Data) ([]models.SeriesCount, error)
+	DeletePoints(data *models.Data) error
 	CreatePoint(data *models.Data) (*client.Point, error)
+	NewPoint(data *models.Data) (*client.Point, error)
+	WritePoints(points []*client.Point) error
 }
 
 // DefaultDatabase a default Database interface implementation
@@ -65,6 +80,14 @@ func (db *DefaultDatabase) Connect() Database {
 	return db
 }
 
+// Ping checks that InfluxDB answers within the timeout
+func (db *DefaultDatabase) Ping(timeout time.Duration) error {
+	if _, _, err := db.Client.Ping(timeout); err != nil {
+		return fmt.Errorf("InfluxDB can't be reached: %s", err)
+	}
+	return nil
+}
+
 // Close all opened connections
 func (db *DefaultDatabase) Close() error {
 	return db.Client.Close()
@@ -72,20 +95,26 @@ func (db *DefaultDatabase) Close() error {
 
 // Saves a point to Influx. A point represents a state of a sensor in time
 func (db *DefaultDatabase) CreatePoint(data *models.Data) (*client.Point, error) {
-	attributes := map[string]interface{}{}
-
-	influxPoints, err := client.NewBatchPoints(client.BatchPointsConfig{
-		Database:  db.Name,
-		Precision: db.Precision,
-	})
+	influxPoint, err := db.NewPoint(data)
 	if err != nil {
-		return nil, fmt.Errorf("Error creating new batch point. Details: %s ", err)
+		return nil, err
+	}
+
+	if err = db.WritePoints([]*client.Point{influxPoint}); err != nil {
+		return nil, err
 	}
 
+	return influxPoint, nil
+}
+
+// NewPoint builds the state point of a data without writing it
+func (db *DefaultDatabase) NewPoint(data *models.Data) (*client.Point, error) {
+	attributes := map[string]interface{}{}
+
 	for k, v := range data.Fields {
 		// check whether there is a static data attribute
 		if strings.Contains(k, "$") {
-			return nil, fmt.Errorf(fmt.Sprintf("Static attributes (aka the ones prefixed with `$)` must be saved in the static data DataBase as it doesn't change over time. Invalid attributed: %s", k))
+			return nil, fmt.Errorf(fmt.Sprintf("Static attributes (aka the ones prefixed with `$)` must be saved in the static data DataBase, through the `/owner/:owner/thing/:thing/node/:node/static` endpoint, as it doesn't change over time. Invalid attributed: %s", k))
 		}
 		attributes[k] = v
 	}
@@ -94,51 +123,304 @@ func (db *DefaultDatabase) CreatePoint(data *models.Data) (*client.Point, error)
 	if err != nil {
 		return nil, fmt.Errorf("Error creating new point. Details %s ", err)
 	}
-	influxPoints.AddPoint(influxPoint)
 
+	return influxPoint, nil
+}
+
+// WritePoints writes a batch of points to Influx with a single request
+func (db *DefaultDatabase) WritePoints(points []*client.Point) error {
+	influxPoints, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:  db.Name,
+		Precision: db.Precision,
+	})
+	if err != nil {
+		return fmt.Errorf("Error creating new batch point. Details: %s ", err)
+	}
+	influxPoints.AddPoints(points)
+
+	start := time.Now()
 	err = db.Client.Write(influxPoints)
+	metrics.WriteDuration.Observe(time.Since(start).Seconds())
 
 	if err != nil {
-		return nil, fmt.Errorf("Error writing the batch points to influx. Details: %s", err)
+		return fmt.Errorf("Error writing the batch points to influx. Details: %w", &WriteError{Err: err})
 	}
 
-	return influxPoint, nil
+	return nil
 }
 
 // Retrieves a point in time
 func (db *DefaultDatabase) GetPoints(data *models.Data) ([]models.StatePoint, error) {
-	var values [][]interface{}
-	var ownerIndex int
-	var thingIndex int
-	var nodeIndex int
+	points, err := db.query(pageStatement(data), data.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	page := points[:0]
+	for _, point := range points {
+		if !data.Options.Cursor.Skips(point) {
+			page = append(page, point)
+		}
+	}
+	if data.Options.Limit > 0 && len(page) > data.Options.Limit {
+		page = page[:data.Options.Limit]
+	}
+
+	return page, nil
+}
+
+// ExportPoints streams every point matching the query to the handler. The points are read
+// in chunks, so the result never has to fit in memory, and the fields keep their types
+func (db *DefaultDatabase) ExportPoints(data *models.Data, handler func(point models.StatePoint) error) error {
+	fieldTypes, err := db.FieldKeys()
+	if err != nil {
+		return err
+	}
+
+	command, params := selectStatement(data).Build()
+	q := client.NewQueryWithParameters(command, db.Name, "", params)
+	q.ChunkSize = exportChunkSize
+
+	response, err := db.Client.QueryAsChunk(q)
+	if err != nil {
+		return fmt.Errorf("Error querying state points. Details: %s", err)
+	}
+	defer response.Close()
+
+	for {
+		chunk, err := response.NextResponse()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading state points. Details: %s", err)
+		}
+		if chunk.Error() != nil {
+			return fmt.Errorf("Error quering Influx for state points. Details: %s", chunk.Error())
+		}
+
+		for _, result := range chunk.Results {
+			for _, series := range result.Series {
+				points, err := statePoints(series, data.Tags)
+				if err != nil {
+					return err
+				}
+				for _, point := range points {
+					for name, value := range point.Attributes {
+						point.Attributes[name] = typedValue(value, fieldTypes[name])
+					}
+					if err = handler(point); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+}
+
+// CountPoints counts the points of every owner/thing/node matching the tags in the data
+// period. A point is counted once, as the count of its most frequent field
+func (db *DefaultDatabase) CountPoints(data *models.Data) ([]models.SeriesCount, error) {
+	sb := influxql.NewSelectBuilder().SelectAggregate("count").From("state")
+	whereTagsAndPeriod(sb, data)
+	sb.GroupByTags("owner", "thing", "node")
+
+	command, params := sb.Build()
+	response, err := db.Client.Query(client.NewQueryWithParameters(command, db.Name, "", params))
+	if err != nil {
+		return nil, fmt.Errorf("Error counting state points. Details: %s", err)
+	}
+	if response.Error() != nil {
+		return nil, fmt.Errorf("Error quering Influx for the count of state points. Details: %s", response.Error())
+	}
+
+	counts := []models.SeriesCount{}
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			count := models.SeriesCount{Tags: series.Tags}
+			for _, row := range series.Values {
+				for j, column := range series.Columns {
+					if column == "time" {
+						continue
+					}
+					if n, ok := typedValue(row[j], "integer").(int64); ok && n > count.Points {
+						count.Points = n
+					}
+				}
+			}
+			counts = append(counts, count)
+		}
+	}
+	return counts, nil
+}
 
-	sb := sqlbuilder.NewSelectBuilder()
-	sb.Select("*")
+// DeletePoints deletes the points of every owner/thing/node matching the tags in the data period
+func (db *DefaultDatabase) DeletePoints(data *models.Data) error {
+	deleteBuilder := influxql.NewDeleteBuilder().From("state")
+	for _, tag := range []string{"owner", "thing", "node"} {
+		if data.Tags[tag] != "" && data.Tags[tag] != "+" {
+			deleteBuilder.WhereEqual(tag, data.Tags[tag])
+		}
+	}
+	if !data.StartDateTime.IsZero() {
+		deleteBuilder.WhereTimeAfter(data.StartDateTime)
+	}
+	if !data.EndDateTime.IsZero() {
+		deleteBuilder.WhereTimeBefore(data.EndDateTime)
+	}
+
+	command, params := deleteBuilder.Build()
+	response, err := db.Client.Query(client.NewQueryWithParameters(command, db.Name, "", params))
+	if err != nil {
+		return fmt.Errorf("Error deleting state points. Details: %s", err)
+	}
+	if response.Error() != nil {
+		return fmt.Errorf("Error quering Influx to delete state points. Details: %s", response.Error())
+	}
+	return nil
+}
+
+// FieldKeys gets the fields of the state measurement and their types
+func (db *DefaultDatabase) FieldKeys() (map[string]string, error) {
+	response, err := db.Client.Query(client.NewQuery(influxql.ShowFieldKeys("state"), db.Name, ""))
+	if err != nil {
+		return nil, fmt.Errorf("Error querying field keys. Details: %s", err)
+	}
+	if response.Error() != nil {
+		return nil, fmt.Errorf("Error quering Influx for field keys. Details: %s", response.Error())
+	}
+
+	fieldTypes := map[string]string{}
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			for _, row := range series.Values {
+				if len(row) == 2 {
+					fieldTypes[fmt.Sprint(row[0])] = fmt.Sprint(row[1])
+				}
+			}
+		}
+	}
+	return fieldTypes, nil
+}
+
+// typedValue converts a JSON number read from InfluxDB back to the type of its field
+func typedValue(value interface{}, fieldType string) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if fieldType == "integer" {
+		if i, err := number.Int64(); err == nil {
+			return i
+		}
+	}
+	if f, err := number.Float64(); err == nil {
+		return f
+	}
+	return value
+}
+
+// selectStatement builds the query of the points of the owners, things and nodes matching
+// the tags in the data period
+func selectStatement(data *models.Data) *influxql.SelectBuilder {
+	sb := influxql.NewSelectBuilder()
+	switch options := data.Options; {
+	case options.Aggregate != "":
+		sb.SelectAggregate(options.Aggregate, options.Fields...)
+	case len(options.Fields) > 0:
+		sb.Select(options.Fields...)
+	default:
+		sb.SelectAll()
+	}
 	sb.From("state")
 
-	if (data.StartDateTime != time.Time{}) {
-		sb.Where(sb.And(sb.GreaterEqualThan("time", data.StartDateTime.Format(time.RFC3339))))
+	whereTagsAndPeriod(sb, data)
+
+	// every owner/thing/node matched by a `+` wildcard comes back as its own series
+	sb.GroupByTags("owner", "thing", "node")
+	if data.Options.GroupBy != "" {
+		sb.GroupByTime(data.Options.GroupBy)
 	}
-	if (data.EndDateTime != time.Time{}) {
-		sb.Where(sb.And(sb.LessEqualThan("time", data.EndDateTime.Format(time.RFC3339))))
+	if data.Options.Fill != "" {
+		sb.Fill(data.Options.Fill)
+	}
+	if data.Options.Descending {
+		sb.OrderByTimeDesc()
 	}
-	if data.Tags["owner"] != "" && data.Tags["owner"] != "+" {
-		sb.Where(sb.And(sb.Equal("owner", data.Tags["owner"])))
+	return sb
+}
+
+// pageStatement builds the query of a page of points, starting at the cursor time. The points
+// are read as a single series in time order, not grouped by tags, so the limit caps the whole
+// page instead of each owner/thing/node. The aggregations are still computed per owner/thing/node
+// in a subquery
+func pageStatement(data *models.Data) *influxql.SelectBuilder {
+	options := data.Options
+	var sb *influxql.SelectBuilder
+	if options.Aggregate != "" {
+		sb = selectStatement(data).Subquery().SelectAll()
+	} else {
+		sb = influxql.NewSelectBuilder()
+		if len(options.Fields) > 0 {
+			sb.Select(options.Fields...).SelectTags("owner", "thing", "node")
+		} else {
+			sb.SelectAll()
+		}
+		sb.From("state")
+		whereTagsAndPeriod(sb, data)
+	}
+	if options.Descending {
+		sb.OrderByTimeDesc()
 	}
-	if data.Tags["thing"] != "" && data.Tags["thing"] != "+" {
-		sb.Where(sb.And(sb.Equal("thing", data.Tags["thing"])))
+
+	// the points at the cursor time are read again, as the ones not returned yet are among them
+	if !options.Cursor.IsZero() {
+		if options.Descending {
+			sb.WhereTime("<=", options.Cursor.Time)
+		} else {
+			sb.WhereTime(">=", options.Cursor.Time)
+		}
 	}
-	if data.Tags["node"] != "" && data.Tags["node"] != "+" {
-		sb.Where(sb.And(sb.Equal("node", data.Tags["node"])))
+	if options.Limit > 0 {
+		sb.Limit(options.Limit + len(options.Cursor.Seen))
 	}
+	return sb
+}
 
-	sql, args := sb.Build()
-	queryFmt := fmt.Sprintf(strings.ReplaceAll(sql, "?", "'%s'"), args...)
+// whereTagsAndPeriod filters the rows of the data period whose tags match, skipping the `+` wildcards
+func whereTagsAndPeriod(sb *influxql.SelectBuilder, data *models.Data) {
+	if (data.StartDateTime != time.Time{}) {
+		sb.WhereTimeAfter(data.StartDateTime)
+	}
+	if (data.EndDateTime != time.Time{}) {
+		sb.WhereTimeBefore(data.EndDateTime)
+	}
+	for _, tag := range []string{"owner", "thing", "node"} {
+		if data.Tags[tag] != "" && data.Tags[tag] != "+" {
+			sb.WhereEqual(tag, data.Tags[tag])
+		}
+	}
+}
 
-	q := client.Query{
-		Command:  queryFmt,
-		Database: db.Name,
+// GetLatestPoints gets the last point of every owner/thing/node matching the tags
+func (db *DefaultDatabase) GetLatestPoints(data *models.Data) ([]models.StatePoint, error) {
+	sb := influxql.NewSelectBuilder()
+	if len(data.Options.Fields) > 0 {
+		sb.Select(data.Options.Fields...)
+	} else {
+		sb.SelectAll()
 	}
+	sb.From("state")
+	whereTagsAndPeriod(sb, data)
+	sb.GroupByTags("owner", "thing", "node").OrderByTimeDesc().Limit(1)
+
+	return db.query(sb, data.Tags)
+}
+
+// query runs a statement and reads the points of every series returned
+func (db *DefaultDatabase) query(sb *influxql.SelectBuilder, queried map[string]string) ([]models.StatePoint, error) {
+	command, params := sb.Build()
+	q := client.NewQueryWithParameters(command, db.Name, "", params)
 
 	response, err := db.Client.Query(q)
 	if err != nil {
@@ -149,40 +431,82 @@ func (db *DefaultDatabase) GetPoints(data *models.Data) ([]models.StatePoint, er
 		return nil, fmt.Errorf("Error quering Influx for state points. Details: %s", response.Error())
 	}
 
-	if len(response.Results) == 0 || len(response.Results[0].Series) == 0 {
-		return make([]models.StatePoint, 0), nil
+	points := make([]models.StatePoint, 0)
+	for _, result := range response.Results {
+		for _, series := range result.Series {
+			seriesPoints, err := statePoints(series, queried)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, seriesPoints...)
+		}
 	}
+	return points, nil
+}
 
-	values = response.Results[0].Series[0].Values
-	cols := response.Results[0].Series[0].Columns
-
+// statePoints reads the points of a series. The columns are resolved for each series, as
+// they change with the fields present in it, and the tags come from the series tag set or
+// from the tag columns
+func statePoints(series influxmodels.Row, queried map[string]string) ([]models.StatePoint, error) {
+	ownerIndex, thingIndex, nodeIndex, timeIndex := -1, -1, -1, -1
 	attributeMapping := map[string]int{}
-	for j, c := range cols {
-		if c == "owner" {
+	for j, c := range series.Columns {
+		switch c {
+		case "owner":
 			ownerIndex = j
-		} else if c == "thing" {
+		case "thing":
 			thingIndex = j
-		} else if c == "node" {
+		case "node":
 			nodeIndex = j
-		} else if c != "time" {
+		case "time":
+			timeIndex = j
+		default:
 			attributeMapping[c] = j
 		}
 	}
+	if timeIndex < 0 {
+		return nil, fmt.Errorf("Error reading series %s: no time column", series.Name)
+	}
+
+	tag := func(row []interface{}, name string, index int) string {
+		if value, ok := series.Tags[name]; ok {
+			return value
+		}
+		return tagValue(row, index, queried[name])
+	}
 
-	r := make([]models.StatePoint, len(values))
-	for i, v := range values {
-		dt, err := time.Parse(time.RFC3339, v[0].(string))
+	r := make([]models.StatePoint, len(series.Values))
+	for i, v := range series.Values {
+		dt, err := time.Parse(time.RFC3339, v[timeIndex].(string))
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing dateTime for result. Error details: %s", err)
 		}
-		attributes := map[string]string{}
+		attributes := map[string]interface{}{}
 		for key, val := range attributeMapping {
 			if v[val] != nil {
-				attributes[key] = v[val].(string)
+				attributes[key] = v[val]
 			}
 		}
-		r[i] = models.StatePoint{DateTime: dt, Owner: v[ownerIndex].(string), Thing: v[thingIndex].(string), Node: v[nodeIndex].(string), Attributes: attributes}
+		r[i] = models.StatePoint{
+			DateTime:   dt,
+			Owner:      tag(v, "owner", ownerIndex),
+			Thing:      tag(v, "thing", thingIndex),
+			Node:       tag(v, "node", nodeIndex),
+			Tags:       series.Tags,
+			Attributes: attributes,
+		}
+		// the rows of a query not grouped by tags hold the tags of their own series
+		if r[i].Tags == nil {
+			r[i].Tags = map[string]string{"owner": r[i].Owner, "thing": r[i].Thing, "node": r[i].Node}
+		}
 	}
-
 	return r, nil
 }
+
+// tagValue reads a tag column, falling back to the queried tag when it was aggregated away
+func tagValue(row []interface{}, index int, queried string) string {
+	if index < 0 || row[index] == nil {
+		return queried
+	}
+	return row[index].(string)
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+	influxmodels "github.com/influxdata/influxdb1-client/models"
+)
+
+func TestStatePointsResolvesColumnsPerSeries(t *testing.T) {
+	queried := map[string]string{"owner": "movbb", "thing": "+", "node": "location"}
+
+	points, err := statePoints(influxmodels.Row{
+		Name:    "state",
+		Tags:    map[string]string{"owner": "movbb", "thing": "297145674599", "node": "location"},
+		Columns: []string{"time", "lat", "lon"},
+		Values:  [][]interface{}{{"2020-04-08T00:04:08Z", -5.52, nil}},
+	}, queried)
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 1)
+	assert.Equal(t, points[0].Thing, "297145674599")
+	assert.Equal(t, points[0].DateTime, time.Date(2020, time.April, 8, 0, 4, 8, 0, time.UTC))
+	assert.DeepEqual(t, points[0].Attributes, map[string]interface{}{"lat": -5.52})
+	assert.DeepEqual(t, points[0].Tags, map[string]string{"owner": "movbb", "thing": "297145674599", "node": "location"})
+
+	// a series with other columns, in another order
+	points, err = statePoints(influxmodels.Row{
+		Name:    "state",
+		Tags:    map[string]string{"owner": "movbb", "thing": "abc1234", "node": "location"},
+		Columns: []string{"battery", "time"},
+		Values:  [][]interface{}{{87, "2020-04-08T00:05:00Z"}},
+	}, queried)
+	assert.NilError(t, err)
+	assert.Equal(t, points[0].Thing, "abc1234")
+	assert.DeepEqual(t, points[0].Attributes, map[string]interface{}{"battery": 87})
+
+	_, err = statePoints(influxmodels.Row{Name: "state", Columns: []string{"lat"}, Values: [][]interface{}{{1.0}}}, queried)
+	assert.Error(t, err, "no time column")
+}
+
+func TestTypedValue(t *testing.T) {
+	assert.Equal(t, typedValue(json.Number("7"), "integer"), int64(7))
+	assert.Equal(t, typedValue(json.Number("7"), "float"), 7.0)
+	assert.Equal(t, typedValue(json.Number("7.5"), "integer"), 7.5)
+	assert.Equal(t, typedValue("gps", "string"), "gps")
+	assert.Equal(t, typedValue(true, "boolean"), true)
+}
+
+func TestPageStatementLimitsThePage(t *testing.T) {
+	cursor := models.Cursor{Time: time.Date(2020, time.April, 8, 0, 4, 8, 0, time.UTC), Seen: [][3]string{{"movbb", "1", "location"}}}
+	data := &models.Data{
+		Tags:    map[string]string{"owner": "movbb", "thing": "+", "node": "location"},
+		Options: models.QueryOptions{Fields: []string{"lat"}, Limit: 11, Cursor: cursor},
+	}
+	command, params := pageStatement(data).Build()
+	assert.Equal(t, command, `SELECT "lat", "owner"::tag, "thing"::tag, "node"::tag FROM "state" WHERE "owner" = $p0 AND "node" = $p1 AND time >= $p2 LIMIT 12`)
+	assert.Equal(t, params["p2"], "2020-04-08T00:04:08Z")
+
+	data.Options = models.QueryOptions{Aggregate: "mean", GroupBy: "1h", Limit: 11, Descending: true}
+	command, _ = pageStatement(data).Build()
+	assert.Equal(t, command, `SELECT * FROM (SELECT mean(*) FROM "state" WHERE "owner" = $p0 AND "node" = $p1 GROUP BY "owner", "thing", "node", time(1h) ORDER BY time DESC) ORDER BY time DESC LIMIT 11`)
+}
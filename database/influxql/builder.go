@@ -0,0 +1,294 @@
+package influxql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// identReplacer escapes the characters that could end a double quoted identifier
+var identReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// stringReplacer escapes the characters that could end a single quoted string literal
+var stringReplacer = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`)
+
+// QuoteIdent quotes a measurement, tag or field name as an InfluxQL identifier
+func QuoteIdent(name string) string {
+	return `"` + identReplacer.Replace(name) + `"`
+}
+
+// QuoteString quotes a value as an InfluxQL string literal
+func QuoteString(value string) string {
+	return `'` + stringReplacer.Replace(value) + `'`
+}
+
+// ShowFieldKeys lists the fields of a measurement and their types
+func ShowFieldKeys(measurement string) string {
+	return "SHOW FIELD KEYS FROM " + QuoteIdent(measurement)
+}
+
+// SelectBuilder builds InfluxQL SELECT statements. Identifiers are quoted and every value
+// is sent as a bind parameter, so user input never becomes part of the statement itself
+type SelectBuilder struct {
+	fields      []string
+	measurement string
+	conditions  []string
+	groupBy     []string
+	fill        string
+	descending  bool
+	limit       int
+	params      map[string]interface{}
+}
+
+// Aggregations are the functions that can be applied to the selected fields
+var Aggregations = map[string]bool{
+	"count":    true,
+	"distinct": true,
+	"first":    true,
+	"last":     true,
+	"max":      true,
+	"mean":     true,
+	"median":   true,
+	"min":      true,
+	"spread":   true,
+	"stddev":   true,
+	"sum":      true,
+}
+
+// durationPattern matches InfluxQL duration literals (Ex: 30s, 5m, 1h, 1d)
+var durationPattern = regexp.MustCompile(`^[1-9][0-9]*(ns|u|ms|s|m|h|d|w)$`)
+
+// fillPattern matches the fill options: null, none, previous, linear or a number
+var fillPattern = regexp.MustCompile(`^(null|none|previous|linear|-?[0-9]+(\.[0-9]+)?)$`)
+
+// ValidDuration tells whether an interval is an InfluxQL duration literal
+func ValidDuration(interval string) bool {
+	return durationPattern.MatchString(interval)
+}
+
+// ValidFill tells whether an option can be used in the fill clause
+func ValidFill(option string) bool {
+	return fillPattern.MatchString(option)
+}
+
+// NewSelectBuilder creates an empty SELECT statement builder
+func NewSelectBuilder() *SelectBuilder {
+	return &SelectBuilder{params: map[string]interface{}{}}
+}
+
+// SelectAll selects every tag and field
+func (sb *SelectBuilder) SelectAll() *SelectBuilder {
+	sb.fields = append(sb.fields, "*")
+	return sb
+}
+
+// Select adds fields by name to the selected columns
+func (sb *SelectBuilder) Select(fields ...string) *SelectBuilder {
+	for _, field := range fields {
+		sb.fields = append(sb.fields, QuoteIdent(field))
+	}
+	return sb
+}
+
+// SelectTags adds tags by name to the selected columns, as selecting only fields leaves them out
+func (sb *SelectBuilder) SelectTags(tags ...string) *SelectBuilder {
+	for _, tag := range tags {
+		sb.fields = append(sb.fields, QuoteIdent(tag)+"::tag")
+	}
+	return sb
+}
+
+// SelectAggregate applies an aggregation to the fields, naming the results after the fields.
+// When no field is given, the aggregation is applied to every field. It panics on unknown
+// functions, so callers must check them against Aggregations first
+func (sb *SelectBuilder) SelectAggregate(function string, fields ...string) *SelectBuilder {
+	function = strings.ToLower(function)
+	if !Aggregations[function] {
+		panic(fmt.Sprintf("Unsupported aggregation %s", function))
+	}
+
+	if len(fields) == 0 {
+		sb.fields = append(sb.fields, function+"(*)")
+	}
+	for _, field := range fields {
+		sb.fields = append(sb.fields, fmt.Sprintf("%s(%s) AS %s", function, QuoteIdent(field), QuoteIdent(field)))
+	}
+	return sb
+}
+
+// Subquery starts a statement selecting from the rows of this one. The tags it groups by
+// become columns of the new statement
+func (sb *SelectBuilder) Subquery() *SelectBuilder {
+	command, params := sb.Build()
+	return &SelectBuilder{measurement: "(" + command + ")", params: params}
+}
+
+// From sets the measurement being queried
+func (sb *SelectBuilder) From(measurement string) *SelectBuilder {
+	sb.measurement = QuoteIdent(measurement)
+	return sb
+}
+
+// WhereEqual filters the rows whose tag or field is equal to the value
+func (sb *SelectBuilder) WhereEqual(name string, value interface{}) *SelectBuilder {
+	return sb.where(name, "=", value)
+}
+
+// WhereTimeAfter filters the rows at or after the given time
+func (sb *SelectBuilder) WhereTimeAfter(t time.Time) *SelectBuilder {
+	return sb.WhereTime(">=", t)
+}
+
+// WhereTimeBefore filters the rows at or before the given time
+func (sb *SelectBuilder) WhereTimeBefore(t time.Time) *SelectBuilder {
+	return sb.WhereTime("<=", t)
+}
+
+// WhereTime compares the row time with the given one using one of <, <=, > or >=
+func (sb *SelectBuilder) WhereTime(operator string, t time.Time) *SelectBuilder {
+	switch operator {
+	case "<", "<=", ">", ">=":
+		return sb.where("time", operator, t.UTC().Format(time.RFC3339Nano))
+	}
+	panic(fmt.Sprintf("Invalid time operator %s", operator))
+}
+
+func (sb *SelectBuilder) where(name string, operator string, value interface{}) *SelectBuilder {
+	param := sb.bind(value)
+	if name == "time" {
+		sb.conditions = append(sb.conditions, fmt.Sprintf("time %s $%s", operator, param))
+	} else {
+		sb.conditions = append(sb.conditions, fmt.Sprintf("%s %s $%s", QuoteIdent(name), operator, param))
+	}
+	return sb
+}
+
+// GroupByTime groups the rows in intervals of the given duration. It panics on invalid durations
+func (sb *SelectBuilder) GroupByTime(interval string) *SelectBuilder {
+	if !ValidDuration(interval) {
+		panic(fmt.Sprintf("Invalid duration %s", interval))
+	}
+	sb.groupBy = append(sb.groupBy, fmt.Sprintf("time(%s)", interval))
+	return sb
+}
+
+// GroupByTags splits the rows in one series per tag set
+func (sb *SelectBuilder) GroupByTags(tags ...string) *SelectBuilder {
+	for _, tag := range tags {
+		sb.groupBy = append(sb.groupBy, QuoteIdent(tag))
+	}
+	return sb
+}
+
+// Fill sets the value reported for the intervals without data. It panics on invalid options
+func (sb *SelectBuilder) Fill(option string) *SelectBuilder {
+	if !ValidFill(option) {
+		panic(fmt.Sprintf("Invalid fill option %s", option))
+	}
+	sb.fill = option
+	return sb
+}
+
+// OrderByTimeDesc returns the newest rows first
+func (sb *SelectBuilder) OrderByTimeDesc() *SelectBuilder {
+	sb.descending = true
+	return sb
+}
+
+// Limit caps the number of rows returned for each series
+func (sb *SelectBuilder) Limit(limit int) *SelectBuilder {
+	sb.limit = limit
+	return sb
+}
+
+// bind registers a value as a bind parameter and returns its name
+func (sb *SelectBuilder) bind(value interface{}) string {
+	name := fmt.Sprintf("p%d", len(sb.params))
+	sb.params[name] = value
+	return name
+}
+
+// DeleteBuilder builds InfluxQL DELETE statements, quoting and binding as SelectBuilder does.
+// InfluxDB only filters deletions by tags and time
+type DeleteBuilder struct {
+	sb *SelectBuilder
+}
+
+// NewDeleteBuilder creates an empty DELETE statement builder
+func NewDeleteBuilder() *DeleteBuilder {
+	return &DeleteBuilder{sb: NewSelectBuilder()}
+}
+
+// From sets the measurement the points are deleted from
+func (db *DeleteBuilder) From(measurement string) *DeleteBuilder {
+	db.sb.From(measurement)
+	return db
+}
+
+// WhereEqual filters the rows whose tag is equal to the value
+func (db *DeleteBuilder) WhereEqual(tag string, value string) *DeleteBuilder {
+	db.sb.WhereEqual(tag, value)
+	return db
+}
+
+// WhereTimeAfter filters the rows at or after the given time
+func (db *DeleteBuilder) WhereTimeAfter(t time.Time) *DeleteBuilder {
+	db.sb.WhereTimeAfter(t)
+	return db
+}
+
+// WhereTimeBefore filters the rows at or before the given time
+func (db *DeleteBuilder) WhereTimeBefore(t time.Time) *DeleteBuilder {
+	db.sb.WhereTimeBefore(t)
+	return db
+}
+
+// Build returns the statement and its bind parameters
+func (db *DeleteBuilder) Build() (string, map[string]interface{}) {
+	command := "DELETE FROM " + db.sb.measurement
+	if len(db.sb.conditions) > 0 {
+		command += " WHERE " + strings.Join(db.sb.conditions, " AND ")
+	}
+	return command, db.sb.params
+}
+
+// Build returns the statement and its bind parameters
+func (sb *SelectBuilder) Build() (string, map[string]interface{}) {
+	var b strings.Builder
+
+	b.WriteString("SELECT ")
+	if len(sb.fields) == 0 {
+		b.WriteString("*")
+	} else {
+		b.WriteString(strings.Join(sb.fields, ", "))
+	}
+	b.WriteString(" FROM ")
+	b.WriteString(sb.measurement)
+
+	if len(sb.conditions) > 0 {
+		b.WriteString(" WHERE ")
+		b.WriteString(strings.Join(sb.conditions, " AND "))
+	}
+
+	if len(sb.groupBy) > 0 {
+		b.WriteString(" GROUP BY ")
+		b.WriteString(strings.Join(sb.groupBy, ", "))
+	}
+
+	if sb.fill != "" {
+		b.WriteString(" fill(")
+		b.WriteString(sb.fill)
+		b.WriteString(")")
+	}
+
+	if sb.descending {
+		b.WriteString(" ORDER BY time DESC")
+	}
+
+	if sb.limit > 0 {
+		b.WriteString(fmt.Sprintf(" LIMIT %d", sb.limit))
+	}
+
+	return b.String(), sb.params
+}
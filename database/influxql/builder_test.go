@@ -0,0 +1,149 @@
+package influxql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestQuoteIdent(t *testing.T) {
+	assert.Equal(t, QuoteIdent("owner"), `"owner"`)
+	assert.Equal(t, QuoteIdent(`own"er`), `"own\"er"`)
+	assert.Equal(t, QuoteIdent(`owner\" OR 1=1`), `"owner\\\" OR 1=1"`)
+	assert.Equal(t, QuoteIdent("state\nDROP MEASUREMENT state"), `"state\nDROP MEASUREMENT state"`)
+}
+
+func TestQuoteString(t *testing.T) {
+	assert.Equal(t, QuoteString("movbb"), `'movbb'`)
+	assert.Equal(t, QuoteString(`movbb' OR owner =~ /.*/ --`), `'movbb\' OR owner =~ /.*/ --'`)
+	assert.Equal(t, QuoteString(`movbb\' OR 1=1`), `'movbb\\\' OR 1=1'`)
+}
+
+func TestSelectBuilder(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2020, time.December, 31, 21, 10, 54, 0, time.UTC)
+
+	command, params := NewSelectBuilder().
+		SelectAll().
+		From("state").
+		WhereTimeAfter(start).
+		WhereTimeBefore(end).
+		WhereEqual("owner", "movbb").
+		WhereEqual("thing", "297145674599").
+		Build()
+
+	assert.Equal(t, command, `SELECT * FROM "state" WHERE time >= $p0 AND time <= $p1 AND "owner" = $p2 AND "thing" = $p3`)
+	assert.DeepEqual(t, params, map[string]interface{}{
+		"p0": "2020-01-01T00:00:00Z",
+		"p1": "2020-12-31T21:10:54Z",
+		"p2": "movbb",
+		"p3": "297145674599",
+	})
+}
+
+func TestSelectBuilderKeepsHostileInputOutOfTheStatement(t *testing.T) {
+	hostile := []string{
+		`movbb' OR '1'='1`,
+		`movbb'; DROP MEASUREMENT state; --`,
+		`x" OR "thing" =~ /.*/`,
+		"movbb\nDROP DATABASE interactws",
+		`\'`,
+	}
+
+	for _, value := range hostile {
+		command, params := NewSelectBuilder().SelectAll().From("state").WhereEqual("owner", value).Build()
+		assert.Equal(t, command, `SELECT * FROM "state" WHERE "owner" = $p0`)
+		assert.Equal(t, params["p0"], value)
+	}
+
+	command, _ := NewSelectBuilder().Select(`lat" FROM secrets --`).From(`state"; DROP MEASUREMENT state; --`).WhereEqual(`owner" = 'x' OR "a`, "b").Build()
+	assert.Equal(t, command, `SELECT "lat\" FROM secrets --" FROM "state\"; DROP MEASUREMENT state; --" WHERE "owner\" = 'x' OR \"a" = $p0`)
+}
+
+func TestSelectBuilderAggregate(t *testing.T) {
+	command, _ := NewSelectBuilder().
+		SelectAggregate("MEAN", "lat", "lon").
+		From("state").
+		WhereEqual("owner", "movbb").
+		GroupByTime("5m").
+		Fill("previous").
+		Build()
+	assert.Equal(t, command, `SELECT mean("lat") AS "lat", mean("lon") AS "lon" FROM "state" WHERE "owner" = $p0 GROUP BY time(5m) fill(previous)`)
+
+	command, _ = NewSelectBuilder().SelectAggregate("count").From("state").GroupByTime("1d").Build()
+	assert.Equal(t, command, `SELECT count(*) FROM "state" GROUP BY time(1d)`)
+
+	assert.Equal(t, ValidDuration("5m"), true)
+	assert.Equal(t, ValidDuration("5m); DROP MEASUREMENT state; --"), false)
+	assert.Equal(t, ValidDuration("0s"), false)
+	assert.Equal(t, ValidFill("-1.5"), true)
+	assert.Equal(t, ValidFill("previous) DROP"), false)
+}
+
+func TestSelectBuilderPage(t *testing.T) {
+	cursor := time.Date(2020, time.April, 8, 0, 23, 0, 500, time.UTC)
+
+	command, params := NewSelectBuilder().
+		SelectAll().
+		From("state").
+		WhereEqual("owner", "movbb").
+		WhereTime("<", cursor).
+		OrderByTimeDesc().
+		Limit(101).
+		Build()
+	assert.Equal(t, command, `SELECT * FROM "state" WHERE "owner" = $p0 AND time < $p1 ORDER BY time DESC LIMIT 101`)
+	assert.Equal(t, params["p1"], "2020-04-08T00:23:00.0000005Z")
+
+	command, _ = NewSelectBuilder().From("state").Limit(0).Build()
+	assert.Equal(t, command, `SELECT * FROM "state"`)
+}
+
+func TestSelectBuilderGroupByTags(t *testing.T) {
+	command, _ := NewSelectBuilder().
+		SelectAggregate("mean", "lat").
+		From("state").
+		GroupByTags("owner", "thing", "node").
+		GroupByTime("1h").
+		Build()
+	assert.Equal(t, command, `SELECT mean("lat") AS "lat" FROM "state" GROUP BY "owner", "thing", "node", time(1h)`)
+}
+
+func TestSelectBuilderSubquery(t *testing.T) {
+	cursor := time.Date(2020, time.April, 8, 0, 0, 0, 0, time.UTC)
+
+	command, params := NewSelectBuilder().
+		SelectAggregate("mean", "lat").
+		From("state").
+		WhereEqual("owner", "movbb").
+		GroupByTags("thing").
+		GroupByTime("1h").
+		Subquery().
+		SelectAll().
+		WhereTime(">=", cursor).
+		Limit(11).
+		Build()
+	assert.Equal(t, command, `SELECT * FROM (SELECT mean("lat") AS "lat" FROM "state" WHERE "owner" = $p0 GROUP BY "thing", time(1h)) WHERE time >= $p1 LIMIT 11`)
+	assert.DeepEqual(t, params, map[string]interface{}{"p0": "movbb", "p1": "2020-04-08T00:00:00Z"})
+}
+
+func TestSelectBuilderTags(t *testing.T) {
+	command, _ := NewSelectBuilder().Select("lat").SelectTags("owner", "thing").From("state").Build()
+	assert.Equal(t, command, `SELECT "lat", "owner"::tag, "thing"::tag FROM "state"`)
+}
+
+func TestDeleteBuilder(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	command, params := NewDeleteBuilder().
+		From("state").
+		WhereEqual("owner", "movbb").
+		WhereEqual("thing", `x' OR '1'='1`).
+		WhereTimeAfter(start).
+		Build()
+	assert.Equal(t, command, `DELETE FROM "state" WHERE "owner" = $p0 AND "thing" = $p1 AND time >= $p2`)
+	assert.Equal(t, params["p1"], `x' OR '1'='1`)
+
+	command, _ = NewDeleteBuilder().From("state").Build()
+	assert.Equal(t, command, `DELETE FROM "state"`)
+}
@@ -1,10 +1,12 @@
 package database
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/labbsr0x/kafka2influxdb/web/config"
 
@@ -17,23 +19,33 @@ type Kafka interface {
 	NewKafka(webBuilder *config.WebBuilder) *DefaultKafka
 	Connect() *DefaultKafka
 	Close() error
-	ListenGroup(handler func(string))
-	consume(handler func(string)) (chan *sarama.ConsumerMessage, chan *sarama.ConsumerError)
+	ListenGroup(ctx context.Context, handler ListenHandler) error
+	consume(handler ListenHandler, stop <-chan struct{}) (<-chan error, *sync.WaitGroup)
 }
 
 // DefaultKafka a default Kafka interface implementation
 type DefaultKafka struct {
 	Addr                string
 	Topic               string
+	GroupID             string
+	StartFrom           *StartPosition
+	DLQTopic            string
+	MaxInFlight         int
+	MaxErrors           int
+	Retry               *RetryPolicy
 	Partition           int
 	Messages            []string
+	Conn                sarama.Client
 	Client              sarama.Consumer
+	Group               sarama.ConsumerGroup
+	Producer            sarama.SyncProducer
 	WithSASL            bool
 	KerberosConfigPath  string
 	KerberosServiceName string
 	KerberosUsername    string
 	KerberosPassword    string
 	KerberosRealm       string
+	running             int32
 }
 
 // NewKafka initializes a default configs from web builder
@@ -41,6 +53,17 @@ func NewKafka(webBuilder *config.WebBuilder) *DefaultKafka {
 	instance := new(DefaultKafka)
 	instance.Addr = webBuilder.KafkaAddr
 	instance.Topic = webBuilder.KafkaTopic
+	instance.GroupID = webBuilder.KafkaGroupID
+	instance.DLQTopic = webBuilder.KafkaDLQTopic
+	instance.MaxInFlight = webBuilder.BatchSize
+	instance.MaxErrors = webBuilder.KafkaMaxErrors
+	instance.Retry = &RetryPolicy{BaseDelay: webBuilder.KafkaRetryBaseDelay, MaxDelay: webBuilder.KafkaRetryMaxDelay, Jitter: 0.2}
+	startFrom, err := ParseStartPosition(webBuilder.KafkaStartFrom)
+	if err != nil {
+		logrus.Errorf("Error parsing Kafka start position: %v", err)
+		panic(fmt.Sprintf("Error parsing Kafka start position: %v", err))
+	}
+	instance.StartFrom = startFrom
 	instance.WithSASL = webBuilder.WithSASL
 	instance.KerberosConfigPath = webBuilder.KerberosConfigPath
 	instance.KerberosServiceName = webBuilder.KerberosServiceName
@@ -78,88 +101,175 @@ func (dk *DefaultKafka) Connect() *DefaultKafka {
 	// saramaBroker.Open(config)
 	// fmt.Println(saramaBroker.Connected())
 
-	client, err := sarama.NewConsumer([]string{dk.Addr}, config)
+	// Consumer groups, timestamp lookups and record headers need at least the 0.10.2 protocol
+	if dk.GroupID != "" || dk.DLQTopic != "" || (dk.StartFrom != nil && dk.StartFrom.Time != time.Time{}) {
+		if !config.Version.IsAtLeast(sarama.V0_10_2_0) {
+			config.Version = sarama.V2_0_0_0
+		}
+	}
+
+	if dk.StartFrom == nil {
+		dk.StartFrom = &StartPosition{Initial: sarama.OffsetOldest}
+	}
+	config.Consumer.Offsets.Initial = dk.StartFrom.Initial
+	config.Producer.Return.Successes = true
+
+	conn, err := sarama.NewClient([]string{dk.Addr}, config)
+	if err != nil {
+		logrus.Errorf("Error creating kafka client: %v", err)
+		panic(fmt.Sprintf("Error creating kafka client: %v", err))
+	}
+
+	client, err := sarama.NewConsumerFromClient(conn)
 	if err != nil {
 		logrus.Errorf("Error creating consumer client: %v", err)
 		panic(fmt.Sprintf("Error creating consumer client: %v", err))
 	}
 
+	dk.Conn = conn
 	dk.Client = client
 
+	if dk.DLQTopic != "" {
+		producer, err := sarama.NewSyncProducerFromClient(conn)
+		if err != nil {
+			logrus.Errorf("Error creating dead letter producer: %v", err)
+			panic(fmt.Sprintf("Error creating dead letter producer: %v", err))
+		}
+		dk.Producer = producer
+	}
+
+	if dk.GroupID != "" {
+		group, err := sarama.NewConsumerGroup([]string{dk.Addr}, dk.GroupID, config)
+		if err != nil {
+			logrus.Errorf("Error creating consumer group %s: %v", dk.GroupID, err)
+			panic(fmt.Sprintf("Error creating consumer group %s: %v", dk.GroupID, err))
+		}
+		dk.Group = group
+	}
+
 	return dk
 }
 
-// Listen all messages from Kafka topic list
-func (dk *DefaultKafka) ListenGroup(handler func([]byte, []byte) error) {
+// Close all opened connections
+func (dk *DefaultKafka) Close() error {
+	if dk.Producer != nil {
+		if err := dk.Producer.Close(); err != nil {
+			return err
+		}
+	}
+	if err := dk.Client.Close(); err != nil {
+		return err
+	}
+	return dk.Conn.Close()
+}
+
+// Listen all messages from Kafka topic list until the context is done. On return, the messages
+// already fetched were acknowledged and the connections are closed. It fails when a partition
+// keeps failing, as told by MaxErrors
+func (dk *DefaultKafka) ListenGroup(ctx context.Context, handler ListenHandler) error {
+	atomic.StoreInt32(&dk.running, 1)
+	defer atomic.StoreInt32(&dk.running, 0)
+
+	if dk.Group != nil {
+		return dk.listenConsumerGroup(ctx, handler)
+	}
+
 	defer func() {
-		if err := dk.Client.Close(); err != nil {
+		if err := dk.Close(); err != nil {
 			logrus.Errorf("Error on closing connection: %v", err)
 			panic(fmt.Sprintf("Error on closing connection: %v", err))
 		}
 	}()
 
-	consumer, errors := dk.consume(handler)
-
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
-
-	// Count how many message processed
-	msgCount := 0
-
-	// Get signnal for finish
-	doneCh := make(chan struct{})
-	go func() {
-		for {
-			select {
-			case <-consumer:
-				msgCount++
-			case consumerError := <-errors:
-				msgCount++
-				logrus.Debugf("Received consumerError\n\t Topic: %s, Partition: %s, Error: %v", string(consumerError.Topic), string(consumerError.Partition), consumerError.Err)
-				doneCh <- struct{}{}
-			case <-signals:
-				logrus.Debugf("Interrupt is detected")
-				doneCh <- struct{}{}
-			}
+	stop := make(chan struct{})
+	failed, processed := dk.consume(handler, stop)
+
+	var err error
+	select {
+	case err = <-failed:
+		logrus.Errorf("Stopping the consumer of topic %s: %v", dk.Topic, err)
+	case <-ctx.Done():
+		logrus.Infof("Stopping the consumer of topic %s", dk.Topic)
+	}
+
+	// stop fetching and wait for the messages already fetched to be acknowledged
+	close(stop)
+	processed.Wait()
+	return err
+}
+
+// Running tells whether the consumer is still listening to the topics
+func (dk *DefaultKafka) Running() bool {
+	return atomic.LoadInt32(&dk.running) == 1
+}
+
+// Ping checks that a broker can be reached, refreshing the cluster metadata when none of
+// the known brokers is connected
+func (dk *DefaultKafka) Ping() error {
+	if dk.Conn == nil || dk.Conn.Closed() {
+		return fmt.Errorf("The Kafka client is closed")
+	}
+
+	for _, broker := range dk.Conn.Brokers() {
+		if connected, _ := broker.Connected(); connected {
+			return nil
 		}
-	}()
+	}
 
-	<-doneCh
-	logrus.Debugf("Processed %d messages", msgCount)
+	if err := dk.Conn.RefreshMetadata(); err != nil {
+		return fmt.Errorf("No Kafka broker can be reached: %v", err)
+	}
+	return nil
 }
 
-func (dk *DefaultKafka) consume(handler func([]byte, []byte) error) (chan *sarama.ConsumerMessage, chan *sarama.ConsumerError) {
-	consumers := make(chan *sarama.ConsumerMessage)
-	errors := make(chan *sarama.ConsumerError)
-	topics, _ := dk.Client.Topics()
+// consume processes the partitions of the topics until stop is closed, yielding the error of the
+// partitions that gave up. The returned wait group is done once the partitions stopped and their
+// fetched messages were acknowledged
+func (dk *DefaultKafka) consume(handler ListenHandler, stop <-chan struct{}) (<-chan error, *sync.WaitGroup) {
+	failed := make(chan error)
+	processed := new(sync.WaitGroup)
 
-	for _, topic := range topics {
-		if strings.Contains(topic, dk.Topic) {
-			partitions, _ := dk.Client.Partitions(topic)
-
-			for _, partition := range partitions {
-				consumer, err := dk.Client.ConsumePartition(topic, partition, sarama.OffsetOldest)
-				if nil != err {
-					logrus.Errorf("Topic %v partitions: %v", topic, err)
-					panic(fmt.Sprintf("Topic %v partitions: %v", topic, err))
-				}
+	for _, topic := range dk.topics() {
+		partitions, _ := dk.Client.Partitions(topic)
 
-				go func(topic string, consumer sarama.PartitionConsumer) {
-					for {
-						select {
-						case consumerError := <-consumer.Errors():
-							errors <- consumerError
-
-						case msg := <-consumer.Messages():
-							consumers <- msg
-							logrus.Debugf("Got message on topic (%s): %s", topic, msg.Value)
-							handler(msg.Key, msg.Value)
-						}
-					}
-				}(topic, consumer)
+		for _, partition := range partitions {
+			offset, err := dk.StartFrom.Resolve(dk.Conn, topic, partition)
+			if err != nil {
+				logrus.Errorf("Topic %v partition %d start offset: %v", topic, partition, err)
+				panic(fmt.Sprintf("Topic %v partition %d start offset: %v", topic, partition, err))
 			}
+
+			listener := newPartitionListener(dk, handler, topic, partition, offset)
+			processed.Add(1)
+			go func() {
+				defer processed.Done()
+				if err := listener.run(stop); err != nil {
+					select {
+					case failed <- err:
+					case <-stop:
+					}
+				}
+			}()
+		}
+	}
+
+	return failed, processed
+}
+
+// topics lists the Kafka topics matching the configured topic term
+func (dk *DefaultKafka) topics() []string {
+	var matched []string
+	topics, err := dk.Client.Topics()
+	if err != nil {
+		logrus.Errorf("Error listing topics: %v", err)
+		return matched
+	}
+
+	for _, topic := range topics {
+		if strings.Contains(topic, dk.Topic) && !dk.isDeadLetterTopic(topic) {
+			matched = append(matched, topic)
 		}
 	}
 
-	return consumers, errors
+	return matched
 }
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers added to the messages sent to the dead letter topic
+const (
+	HeaderErrorReason     = "x-error-reason"
+	HeaderSourceTopic     = "x-source-topic"
+	HeaderSourcePartition = "x-source-partition"
+	HeaderSourceOffset    = "x-source-offset"
+	HeaderFailedAt        = "x-failed-at"
+)
+
+// deadLetter produces a message rejected by the listen handler to the dead letter topic,
+// keeping its key, value and headers. It fails when there is no dead letter topic configured
+func (dk *DefaultKafka) deadLetter(msg *sarama.ConsumerMessage, reason error) error {
+	if dk.Producer == nil {
+		return fmt.Errorf("No dead letter topic configured. Message lost: %v", reason)
+	}
+
+	source := map[string]string{
+		HeaderSourceTopic:     msg.Topic,
+		HeaderSourcePartition: strconv.Itoa(int(msg.Partition)),
+		HeaderSourceOffset:    strconv.FormatInt(msg.Offset, 10),
+	}
+
+	headers := []sarama.RecordHeader{}
+	for _, h := range msg.Headers {
+		key := string(h.Key)
+		// a replayed message keeps pointing to where it was first consumed
+		if _, ok := source[key]; ok {
+			source[key] = string(h.Value)
+			continue
+		}
+		if key == HeaderErrorReason || key == HeaderFailedAt {
+			continue
+		}
+		headers = append(headers, *h)
+	}
+
+	for _, key := range []string{HeaderSourceTopic, HeaderSourcePartition, HeaderSourceOffset} {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(source[key])})
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderErrorReason), Value: []byte(reason.Error())},
+		sarama.RecordHeader{Key: []byte(HeaderFailedAt), Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+
+	partition, offset, err := dk.Producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   dk.DLQTopic,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("Error producing to dead letter topic %s: %v", dk.DLQTopic, err)
+	}
+
+	logrus.Warnf("Message sent to dead letter topic %s (partition %d, offset %d). Source: %s/%s/%s, Reason: %v",
+		dk.DLQTopic, partition, offset, source[HeaderSourceTopic], source[HeaderSourcePartition], source[HeaderSourceOffset], reason)
+	return nil
+}
+
+// replayIdleCheck is how long the replay of a partition waits for a message before checking
+// whether it already caught up with the end of the partition
+const replayIdleCheck = 500 * time.Millisecond
+
+// ReplayDeadLetters reads the dead letter topic up to its current end and hands every message to
+// the handler again. Messages failing once more are sent back to the dead letter topic. The
+// progress is committed under the replay consumer group, so a new replay resumes where the last
+// one stopped, and the configured start position only applies to the first one
+func (dk *DefaultKafka) ReplayDeadLetters(handler ListenHandler) error {
+	if dk.DLQTopic == "" {
+		return fmt.Errorf("The dead letter topic must be provided to replay its messages")
+	}
+
+	partitions, err := dk.Client.Partitions(dk.DLQTopic)
+	if err != nil {
+		return fmt.Errorf("Error listing partitions of topic %s: %v", dk.DLQTopic, err)
+	}
+
+	offsets, err := sarama.NewOffsetManagerFromClient(dk.replayGroupID(), dk.Conn)
+	if err != nil {
+		return fmt.Errorf("Error managing the offsets of group %s: %v", dk.replayGroupID(), err)
+	}
+	defer func() {
+		// flushes the progress of the last partitions
+		if err := offsets.Close(); err != nil {
+			logrus.Errorf("Error committing replay progress: %v", err)
+		}
+	}()
+
+	replayed := 0
+	for _, partition := range partitions {
+		count, err := dk.replayPartition(offsets, partition, handler)
+		replayed += count
+		if err != nil {
+			logrus.Infof("Replayed %d messages from %s", replayed, dk.DLQTopic)
+			return err
+		}
+	}
+
+	logrus.Infof("Replayed %d messages from %s", replayed, dk.DLQTopic)
+	return nil
+}
+
+// replayPartition replays a partition of the dead letter topic from the offset committed by the
+// replay group up to the end of the partition when the replay started
+func (dk *DefaultKafka) replayPartition(offsets sarama.OffsetManager, partition int32, handler ListenHandler) (int, error) {
+	progress, err := offsets.ManagePartition(dk.DLQTopic, partition)
+	if err != nil {
+		return 0, fmt.Errorf("Error fetching replay progress of partition %d: %v", partition, err)
+	}
+	defer progress.AsyncClose()
+	go func() {
+		for err := range progress.Errors() {
+			logrus.Errorf("Error committing replay progress of partition %d: %v", partition, err)
+		}
+	}()
+
+	end, err := dk.Conn.GetOffset(dk.DLQTopic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting end offset of partition %d: %v", partition, err)
+	}
+
+	start, _ := progress.NextOffset()
+	if start < 0 {
+		if start, err = dk.StartFrom.Resolve(dk.Conn, dk.DLQTopic, partition); err != nil {
+			return 0, err
+		}
+	}
+	if start < 0 {
+		if start, err = dk.Conn.GetOffset(dk.DLQTopic, partition, start); err != nil {
+			return 0, fmt.Errorf("Error getting start offset of partition %d: %v", partition, err)
+		}
+	}
+	if start >= end {
+		return 0, nil
+	}
+
+	consumer, err := dk.Client.ConsumePartition(dk.DLQTopic, partition, start)
+	if err != nil {
+		return 0, fmt.Errorf("Error consuming partition %d of topic %s: %v", partition, dk.DLQTopic, err)
+	}
+	go func() {
+		for consumerError := range consumer.Errors() {
+			logrus.Errorf("Error consuming partition %d of topic %s: %v", partition, dk.DLQTopic, consumerError.Err)
+		}
+	}()
+	defer consumer.AsyncClose()
+
+	replayed := 0
+	// process may stop before the end, so the forwarding stops with it
+	done := make(chan struct{})
+	err = dk.process(replayMessages(consumer, end, replayIdleCheck, done), handler, dk.MaxInFlight, func(msg *sarama.ConsumerMessage) {
+		progress.MarkOffset(msg.Offset+1, "")
+		replayed++
+	})
+	close(done)
+	return replayed, err
+}
+
+// replayMessages forwards the messages of the partition until the end offset, as the messages
+// failing again are appended to the same topic. Missing offsets, like compacted messages and
+// transaction markers, aren't waited for: it also stops when no message arrives while the
+// partition consumer already fetched up to the end. It stops forwarding once done is closed
+func replayMessages(consumer sarama.PartitionConsumer, end int64, idleCheck time.Duration, done <-chan struct{}) <-chan *sarama.ConsumerMessage {
+	messages := make(chan *sarama.ConsumerMessage)
+	go func() {
+		defer close(messages)
+
+		idle := time.NewTicker(idleCheck)
+		defer idle.Stop()
+		received := false
+		for {
+			select {
+			case msg, ok := <-consumer.Messages():
+				if !ok {
+					return
+				}
+				received = true
+				if msg.Offset >= end {
+					return
+				}
+				select {
+				case messages <- msg:
+				case <-done:
+					return
+				}
+				if msg.Offset >= end-1 {
+					return
+				}
+			case <-idle.C:
+				if !received && consumer.HighWaterMarkOffset() >= end {
+					return
+				}
+				received = false
+			case <-done:
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+// replayGroupID is the consumer group committing the replay progress, apart from the group
+// consuming the topics
+func (dk *DefaultKafka) replayGroupID() string {
+	if dk.GroupID != "" {
+		return dk.GroupID + "-replay"
+	}
+	return dk.DLQTopic + "-replay"
+}
+
+// isDeadLetterTopic tells whether a topic is the one configured as dead letter topic
+func (dk *DefaultKafka) isDeadLetterTopic(topic string) bool {
+	return dk.DLQTopic != "" && topic == dk.DLQTopic
+}
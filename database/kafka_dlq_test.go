@@ -0,0 +1,64 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// fakePartitionConsumer yields the given messages and reports a fixed high water mark
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	messages      chan *sarama.ConsumerMessage
+	highWaterMark int64
+}
+
+func newFakePartitionConsumer(highWaterMark int64, offsets ...int64) *fakePartitionConsumer {
+	pc := &fakePartitionConsumer{messages: make(chan *sarama.ConsumerMessage, len(offsets)), highWaterMark: highWaterMark}
+	for _, offset := range offsets {
+		pc.messages <- &sarama.ConsumerMessage{Offset: offset}
+	}
+	return pc
+}
+
+func (pc *fakePartitionConsumer) Messages() <-chan *sarama.ConsumerMessage {
+	return pc.messages
+}
+
+func (pc *fakePartitionConsumer) HighWaterMarkOffset() int64 {
+	return pc.highWaterMark
+}
+
+func replayedOffsets(messages <-chan *sarama.ConsumerMessage) []int64 {
+	offsets := []int64{}
+	for msg := range messages {
+		offsets = append(offsets, msg.Offset)
+	}
+	return offsets
+}
+
+func TestReplayMessagesStopsAtEnd(t *testing.T) {
+	// 3 and 4 were appended by the replay itself
+	pc := newFakePartitionConsumer(5, 0, 1, 2, 3, 4)
+	assert.DeepEqual(t, replayedOffsets(replayMessages(pc, 3, time.Hour, nil)), []int64{0, 1, 2})
+}
+
+func TestReplayMessagesSkipsMissingLastOffset(t *testing.T) {
+	// offset 2 is a transaction marker, so it never arrives
+	pc := newFakePartitionConsumer(3, 0, 1)
+	assert.DeepEqual(t, replayedOffsets(replayMessages(pc, 3, 10*time.Millisecond, nil)), []int64{0, 1})
+}
+
+func TestReplayMessagesStopsWhenDone(t *testing.T) {
+	pc := newFakePartitionConsumer(5, 0, 1, 2)
+	done := make(chan struct{})
+	messages := replayMessages(pc, 5, time.Hour, done)
+	assert.Equal(t, (<-messages).Offset, int64(0))
+
+	// nothing reads the messages anymore, as when the replay stops at a failed message
+	close(done)
+	for range messages {
+	}
+}
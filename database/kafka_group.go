@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/web/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// groupHandler hands the messages of the claimed partitions to the listen handler
+// and marks their offsets once they were processed or sent to the dead letter topic
+type groupHandler struct {
+	kafka   *DefaultKafka
+	handler ListenHandler
+	lock    sync.Mutex
+	cancel  context.CancelFunc
+	failure error
+}
+
+// begin prepares the handler for a new session, which the cancel function ends
+func (h *groupHandler) begin(cancel context.CancelFunc) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.cancel = cancel
+	h.failure = nil
+}
+
+// fail ends the session, so the group is joined again from the last committed offsets
+func (h *groupHandler) fail(err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.failure == nil {
+		h.failure = err
+	}
+	h.cancel()
+}
+
+// failed returns the error that ended the last session, if any
+func (h *groupHandler) failed() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.failure
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim. Claimed partitions
+// without a committed offset are moved to the configured start position
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	logrus.Infof("Consumer group session started. Member: %s, Claims: %v", session.MemberID(), session.Claims())
+
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			committed, err := h.kafka.committedOffset(topic, partition)
+			if err != nil {
+				logrus.Errorf("Error fetching committed offset. Topic: %s, Partition: %d, Error: %v", topic, partition, err)
+				continue
+			}
+			if committed >= 0 {
+				continue
+			}
+
+			offset, err := h.kafka.StartFrom.Resolve(h.kafka.Conn, topic, partition)
+			if err != nil {
+				logrus.Errorf("Error resolving start offset. Topic: %s, Partition: %d, Error: %v", topic, partition, err)
+				continue
+			}
+			// oldest and newest are handled by the consumer group initial offset
+			if offset >= 0 {
+				logrus.Infof("Starting topic %s partition %d at offset %d", topic, partition, offset)
+				session.MarkOffset(topic, partition, offset, "")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	logrus.Infof("Consumer group session finished. Member: %s", session.MemberID())
+	return nil
+}
+
+// ConsumeClaim processes the messages of a single topic partition, marking their offsets in order.
+// A message that can't be acknowledged ends the session, as the claim can't be consumed again
+// from that message within the same session
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	err := h.kafka.process(claim.Messages(), h.handler, h.kafka.MaxInFlight, func(msg *sarama.ConsumerMessage) {
+		session.MarkMessage(msg, "")
+		metrics.SetConsumerLag(msg.Topic, msg.Partition, claim.HighWaterMarkOffset(), msg.Offset)
+	})
+	if err != nil {
+		h.fail(err)
+	}
+
+	return err
+}
+
+// listenConsumerGroup joins the consumer group and resumes from its committed offsets. When the
+// context is done, the session ends once the claimed messages were acknowledged, committing
+// their offsets. Failed sessions are joined again with backoff until MaxErrors in a row
+func (dk *DefaultKafka) listenConsumerGroup(ctx context.Context, handler ListenHandler) error {
+	defer func() {
+		if err := dk.Group.Close(); err != nil {
+			logrus.Errorf("Error on closing consumer group: %v", err)
+		}
+		if err := dk.Close(); err != nil {
+			logrus.Errorf("Error on closing connection: %v", err)
+		}
+	}()
+
+	topics := dk.topics()
+	gh := &groupHandler{kafka: dk, handler: handler}
+
+	go func() {
+		for err := range dk.Group.Errors() {
+			logrus.Errorf("Received consumer group error: %v", err)
+		}
+	}()
+
+	// Consume returns at every rebalance, so it must be called again to rejoin the group
+	failures := 0
+	for {
+		session, cancel := context.WithCancel(ctx)
+		joined := time.Now()
+		gh.begin(cancel)
+		err := dk.Group.Consume(session, topics, gh)
+		cancel()
+		if err == nil {
+			err = gh.failed()
+		}
+		if ctx.Err() != nil {
+			logrus.Infof("Left consumer group %s", dk.GroupID)
+			return nil
+		}
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		// a session that lasted longer than the longest retry delay had recovered
+		if time.Since(joined) >= dk.Retry.MaxDelay {
+			failures = 0
+		}
+		failures++
+		if dk.MaxErrors > 0 && failures >= dk.MaxErrors {
+			return fmt.Errorf("Consumer group %s failed %d times in a row. Last error: %v", dk.GroupID, failures, err)
+		}
+		delay := dk.Retry.Backoff(failures)
+		logrus.Errorf("Error consuming group %s, joining again in %s: %v", dk.GroupID, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// committedOffset fetches the offset committed by the consumer group for a partition, -1 when there is none
+func (dk *DefaultKafka) committedOffset(topic string, partition int32) (int64, error) {
+	coordinator, err := dk.Conn.Coordinator(dk.GroupID)
+	if err != nil {
+		return 0, err
+	}
+
+	req := new(sarama.OffsetFetchRequest)
+	req.Version = 1
+	req.ConsumerGroup = dk.GroupID
+	req.AddPartition(topic, partition)
+
+	resp, err := coordinator.FetchOffset(req)
+	if err != nil {
+		return 0, err
+	}
+
+	block := resp.GetBlock(topic, partition)
+	if block == nil {
+		return 0, sarama.ErrIncompleteResponse
+	}
+	if block.Err != sarama.ErrNoError {
+		return 0, block.Err
+	}
+
+	return block.Offset, nil
+}
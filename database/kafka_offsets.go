@@ -0,0 +1,92 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// StartPosition defines where the consumption of a partition without committed offsets begins.
+// It is either the oldest or newest offset, the first offset after a point in time
+// or an explicit offset per partition
+type StartPosition struct {
+	Initial int64
+	Time    time.Time
+	Offsets map[string]int64
+}
+
+// ParseStartPosition parses the `kafka-start-from` option. Accepted values are `oldest`, `newest`,
+// a RFC3339 timestamp or a comma separated list of `[topic:]partition=offset` pairs
+// (Ex: "oldest", "2020-05-01T00:00:00Z", "0=1200,1=350", "owner-movbb:0=1200")
+func ParseStartPosition(value string) (*StartPosition, error) {
+	position := &StartPosition{Initial: sarama.OffsetOldest}
+
+	switch value = strings.TrimSpace(value); value {
+	case "", "oldest":
+		return position, nil
+	case "newest":
+		position.Initial = sarama.OffsetNewest
+		return position, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		position.Time = t
+		return position, nil
+	}
+
+	position.Offsets = map[string]int64{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("The start position must be one of [ oldest | newest | %s | [topic:]partition=offset,... ]. Got: %s", time.RFC3339, value)
+		}
+
+		partitionKey := parts[0]
+		partitionNumber := partitionKey
+		if i := strings.LastIndex(partitionKey, ":"); i >= 0 {
+			partitionNumber = partitionKey[i+1:]
+		}
+		if _, err := strconv.ParseInt(partitionNumber, 10, 32); err != nil {
+			return nil, fmt.Errorf("Invalid partition `%s` in start position: %s", partitionKey, value)
+		}
+
+		offset, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("Invalid offset `%s` for partition `%s` in start position", parts[1], partitionKey)
+		}
+		position.Offsets[partitionKey] = offset
+	}
+
+	return position, nil
+}
+
+// Resolve returns the offset the given partition must start from. Partitions missing
+// in an explicit offset list start from the oldest offset
+func (sp *StartPosition) Resolve(client sarama.Client, topic string, partition int32) (int64, error) {
+	if sp.Offsets != nil {
+		if offset, ok := sp.Offsets[fmt.Sprintf("%s:%d", topic, partition)]; ok {
+			return offset, nil
+		}
+		if offset, ok := sp.Offsets[strconv.Itoa(int(partition))]; ok {
+			return offset, nil
+		}
+		return sp.Initial, nil
+	}
+
+	if (sp.Time != time.Time{}) {
+		offset, err := client.GetOffset(topic, partition, sp.Time.UnixNano()/int64(time.Millisecond))
+		if err != nil {
+			return 0, fmt.Errorf("Error resolving offset at %s for topic %s partition %d: %v", sp.Time.Format(time.RFC3339), topic, partition, err)
+		}
+		// no message was produced after the given time
+		if offset < 0 {
+			return client.GetOffset(topic, partition, sarama.OffsetNewest)
+		}
+		return offset, nil
+	}
+
+	return sp.Initial, nil
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestParseStartPosition(t *testing.T) {
+	position, err := ParseStartPosition("oldest")
+	assert.NilError(t, err)
+	assert.Equal(t, position.Initial, sarama.OffsetOldest)
+
+	position, err = ParseStartPosition("newest")
+	assert.NilError(t, err)
+	assert.Equal(t, position.Initial, sarama.OffsetNewest)
+
+	position, err = ParseStartPosition("2020-05-01T00:00:00Z")
+	assert.NilError(t, err)
+	assert.Equal(t, position.Time, time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC))
+
+	position, err = ParseStartPosition("0=1200, owner-movbb:1=350")
+	assert.NilError(t, err)
+	assert.Equal(t, position.Offsets["0"], int64(1200))
+	assert.Equal(t, position.Offsets["owner-movbb:1"], int64(350))
+
+	offset, err := position.Resolve(nil, "owner-movbb", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, offset, int64(350))
+
+	offset, err = position.Resolve(nil, "owner-movbb", 2)
+	assert.NilError(t, err)
+	assert.Equal(t, offset, sarama.OffsetOldest)
+}
+
+func TestParseStartPositionInvalid(t *testing.T) {
+	for _, value := range []string{"yesterday", "a=1", "0=-5", "0=1,1"} {
+		_, err := ParseStartPosition(value)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/web/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// partitionAction is what a partition listener does about an error of its consumer
+type partitionAction string
+
+const (
+	// partitionRecover errors are retried by the partition consumer itself, like leader changes
+	partitionRecover partitionAction = "recover"
+	// partitionReopen errors may have left the partition consumer stuck, so it is opened again
+	partitionReopen partitionAction = "reopen"
+	// partitionReset errors mean the offset is gone, so the partition is opened again at the
+	// initial offset, oldest or newest
+	partitionReset partitionAction = "reset"
+)
+
+// classifyPartitionError tells what to do about an error of a partition consumer
+func classifyPartitionError(err error) partitionAction {
+	switch err {
+	case sarama.ErrOffsetOutOfRange:
+		return partitionReset
+	case sarama.ErrNotLeaderForPartition, sarama.ErrLeaderNotAvailable, sarama.ErrUnknownTopicOrPartition,
+		sarama.ErrReplicaNotAvailable, sarama.ErrRequestTimedOut, sarama.ErrNetworkException, sarama.ErrOutOfBrokers:
+		return partitionRecover
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return partitionRecover
+	}
+	return partitionReopen
+}
+
+// partitionListener keeps consuming a partition through the errors of its consumer, opening it
+// again from the last acknowledged message when needed. It gives up once the partition fails
+// `MaxErrors` times in a row. The errors the consumer recovers from by itself aren't failures, and
+// a partition acknowledging messages or consuming without errors for the longest retry delay has
+// recovered
+type partitionListener struct {
+	kafka     *DefaultKafka
+	handler   ListenHandler
+	topic     string
+	partition int32
+	offset    int64
+	failures  int32
+}
+
+// newPartitionListener creates a listener starting the partition at the offset, which may also
+// be sarama.OffsetOldest or sarama.OffsetNewest
+func newPartitionListener(dk *DefaultKafka, handler ListenHandler, topic string, partition int32, offset int64) *partitionListener {
+	return &partitionListener{kafka: dk, handler: handler, topic: topic, partition: partition, offset: offset}
+}
+
+// run consumes the partition until stop is closed, returning only once the messages already
+// fetched were acknowledged. It fails when the partition keeps failing
+func (pl *partitionListener) run(stop <-chan struct{}) error {
+	for {
+		reopen := pl.consume(stop)
+		if reopen == nil {
+			return nil
+		}
+		if giveUp := pl.fail(reopen); giveUp != nil {
+			return giveUp
+		}
+
+		delay := pl.kafka.Retry.Backoff(int(atomic.LoadInt32(&pl.failures)))
+		logrus.Warnf("Reopening topic %s partition %d at offset %d in %s: %v", pl.topic, pl.partition, pl.offset, delay, reopen)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// consume opens the partition and processes its messages until stop is closed or the consumer
+// has to be opened again
+func (pl *partitionListener) consume(stop <-chan struct{}) (reopen error) {
+	consumer, err := pl.kafka.Client.ConsumePartition(pl.topic, pl.partition, pl.offset)
+	if err != nil {
+		if pl.record(err) == partitionReset {
+			pl.offset = pl.kafka.StartFrom.Initial
+		}
+		return err
+	}
+
+	// a message that can't be acknowledged stops the processing, so the partition is
+	// opened again at that message
+	var unacknowledged error
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		unacknowledged = pl.kafka.process(consumer.Messages(), pl.handler, pl.kafka.MaxInFlight, func(msg *sarama.ConsumerMessage) {
+			pl.offset = msg.Offset + 1
+			atomic.StoreInt32(&pl.failures, 0)
+			metrics.SetConsumerLag(msg.Topic, msg.Partition, consumer.HighWaterMarkOffset(), msg.Offset)
+		})
+	}()
+
+	reopen = pl.watch(consumer, processed, stop)
+
+	// the errors are drained until the consumer closes, so it never blocks on them
+	consumer.AsyncClose()
+	go func() {
+		for range consumer.Errors() {
+		}
+	}()
+	<-processed
+
+	if reopen != nil && unacknowledged != nil {
+		reopen = unacknowledged
+	}
+	if reopen != nil && classifyPartitionError(reopen) == partitionReset {
+		pl.offset = pl.kafka.StartFrom.Initial
+	}
+	return
+}
+
+// watch waits for the errors of the consumer until one of them requires to open it again
+func (pl *partitionListener) watch(consumer sarama.PartitionConsumer, processed <-chan struct{}, stop <-chan struct{}) (reopen error) {
+	quiet := time.NewTimer(pl.kafka.Retry.MaxDelay)
+	defer quiet.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-processed:
+			// a message wasn't acknowledged or the consumer closed its messages by itself
+			return fmt.Errorf("The consumer of topic %s partition %d stopped", pl.topic, pl.partition)
+		case <-quiet.C:
+			atomic.StoreInt32(&pl.failures, 0)
+		case consumerError := <-consumer.Errors():
+			if pl.record(consumerError.Err) != partitionRecover {
+				return consumerError.Err
+			}
+			logrus.Warnf("Topic %s partition %d error: %v", pl.topic, pl.partition, consumerError.Err)
+		}
+	}
+}
+
+// record counts the error by the action it requires
+func (pl *partitionListener) record(err error) partitionAction {
+	action := classifyPartitionError(err)
+	metrics.PartitionErrors.WithLabelValues(pl.topic, strconv.Itoa(int(pl.partition)), string(action)).Inc()
+	return action
+}
+
+// fail counts a consecutive failure of the partition, returning an error once there are too many
+func (pl *partitionListener) fail(err error) error {
+	failures := atomic.AddInt32(&pl.failures, 1)
+	if pl.kafka.MaxErrors > 0 && int(failures) >= pl.kafka.MaxErrors {
+		return fmt.Errorf("Topic %s partition %d failed %d times in a row. Last error: %v", pl.topic, pl.partition, failures, err)
+	}
+	return nil
+}
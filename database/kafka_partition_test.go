@@ -0,0 +1,165 @@
+package database
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// consumerSequence opens each partition consumer from the next mock consumer, as a mock
+// consumer opens a partition only once
+type consumerSequence struct {
+	sarama.Consumer
+	lock      sync.Mutex
+	consumers []*mocks.Consumer
+	opened    chan int
+}
+
+func newConsumerSequence(t *testing.T, offsets ...int64) (*consumerSequence, []*mocks.PartitionConsumer) {
+	seq := &consumerSequence{opened: make(chan int, len(offsets))}
+	partitions := make([]*mocks.PartitionConsumer, len(offsets))
+	for i, offset := range offsets {
+		config := sarama.NewConfig()
+		config.Consumer.Return.Errors = true
+		consumer := mocks.NewConsumer(t, config)
+		partitions[i] = consumer.ExpectConsumePartition("state", 0, offset)
+		seq.consumers = append(seq.consumers, consumer)
+	}
+	return seq, partitions
+}
+
+func (seq *consumerSequence) ConsumePartition(topic string, partition int32, offset int64) (sarama.PartitionConsumer, error) {
+	seq.lock.Lock()
+	defer seq.lock.Unlock()
+
+	if len(seq.consumers) == 0 {
+		return nil, errors.New("No more partition consumers")
+	}
+	consumer := seq.consumers[0]
+	seq.consumers = seq.consumers[1:]
+	seq.opened <- len(seq.consumers)
+	return consumer.ConsumePartition(topic, partition, offset)
+}
+
+func newTestKafka(client sarama.Consumer, maxErrors int) *DefaultKafka {
+	return &DefaultKafka{
+		Client:      client,
+		StartFrom:   &StartPosition{Initial: sarama.OffsetOldest},
+		MaxInFlight: 10,
+		MaxErrors:   maxErrors,
+		Retry:       &RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Hour},
+	}
+}
+
+func acceptAll(msg *models.Message) <-chan error {
+	return Result(nil)
+}
+
+func TestClassifyPartitionError(t *testing.T) {
+	assert.Equal(t, classifyPartitionError(sarama.ErrOffsetOutOfRange), partitionReset)
+	assert.Equal(t, classifyPartitionError(sarama.ErrNotLeaderForPartition), partitionRecover)
+	assert.Equal(t, classifyPartitionError(&net.OpError{Op: "read", Err: errors.New("connection reset")}), partitionRecover)
+	assert.Equal(t, classifyPartitionError(errors.New("kafka: broken")), partitionReopen)
+}
+
+func TestPartitionListenerReopensAfterLastAcknowledged(t *testing.T) {
+	seq, partitions := newConsumerSequence(t, 0, 3)
+	dk := newTestKafka(seq, 3)
+
+	partitions[0].YieldMessage(&sarama.ConsumerMessage{Value: []byte("1")})
+	partitions[0].YieldMessage(&sarama.ConsumerMessage{Value: []byte("2")})
+	partitions[0].YieldError(errors.New("kafka: broken"))
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	listener := newPartitionListener(dk, acceptAll, "state", 0, 0)
+	go func() {
+		done <- listener.run(stop)
+	}()
+
+	<-seq.opened
+	<-seq.opened
+	close(stop)
+	assert.NilError(t, <-done)
+	assert.Equal(t, listener.offset, int64(3))
+}
+
+func TestPartitionListenerResetsOutOfRangeOffset(t *testing.T) {
+	seq, partitions := newConsumerSequence(t, 5, sarama.OffsetOldest)
+	dk := newTestKafka(seq, 3)
+	partitions[0].YieldError(sarama.ErrOffsetOutOfRange)
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- newPartitionListener(dk, acceptAll, "state", 0, 5).run(stop)
+	}()
+
+	<-seq.opened
+	<-seq.opened
+	close(stop)
+	assert.NilError(t, <-done)
+}
+
+func TestPartitionListenerGivesUp(t *testing.T) {
+	seq, partitions := newConsumerSequence(t, 0, 0, 0)
+	dk := newTestKafka(seq, 3)
+	for _, partition := range partitions {
+		partition.YieldError(errors.New("kafka: broken"))
+	}
+
+	err := newPartitionListener(dk, acceptAll, "state", 0, 0).run(make(chan struct{}))
+	assert.Error(t, err, "failed 3 times in a row")
+	assert.Equal(t, len(seq.opened), 3)
+}
+
+func TestPartitionListenerOnlyCountsRecoverableErrors(t *testing.T) {
+	seq, partitions := newConsumerSequence(t, 0, 0)
+	dk := newTestKafka(seq, 2)
+	partitions[0].YieldError(sarama.ErrNotLeaderForPartition)
+	partitions[0].YieldError(sarama.ErrLeaderNotAvailable)
+	partitions[0].YieldError(sarama.ErrRequestTimedOut)
+	partitions[0].YieldError(errors.New("kafka: broken"))
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- newPartitionListener(dk, acceptAll, "state", 0, 0).run(stop)
+	}()
+
+	// the partition is opened again after the first error that isn't recovered
+	<-seq.opened
+	<-seq.opened
+	close(stop)
+	assert.NilError(t, <-done)
+}
+
+func TestPartitionListenerRecoversAfterQuietPeriod(t *testing.T) {
+	seq, partitions := newConsumerSequence(t, 0, 0, 0)
+	dk := newTestKafka(seq, 2)
+	dk.Retry.MaxDelay = 10 * time.Millisecond
+	partitions[0].YieldError(errors.New("kafka: broken"))
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- newPartitionListener(dk, acceptAll, "state", 0, 0).run(stop)
+	}()
+
+	<-seq.opened
+	<-seq.opened
+	// the second failure comes after a quiet period, so it isn't the second in a row
+	time.Sleep(50 * time.Millisecond)
+	partitions[1].YieldError(errors.New("kafka: broken"))
+	<-seq.opened
+	close(stop)
+	assert.NilError(t, <-done)
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+	"github.com/labbsr0x/kafka2influxdb/web/metrics"
+
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+)
+
+// ListenHandler processes a message consumed from Kafka. The returned channel yields
+// the result once the message was persisted
+type ListenHandler func(msg *models.Message) <-chan error
+
+// inFlight is a message handed to the listen handler and not acknowledged yet
+type inFlight struct {
+	msg    *sarama.ConsumerMessage
+	result <-chan error
+}
+
+// newMessage converts a consumed record to the message given to listen handlers
+func newMessage(msg *sarama.ConsumerMessage) *models.Message {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+
+	return &models.Message{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Timestamp: msg.Timestamp,
+	}
+}
+
+// process hands the messages of a partition to the handler without waiting for them to be
+// persisted, and acknowledges them in order as their results arrive. Messages rejected for good
+// are sent to the dead letter topic and acknowledged only if that succeeds. At most `maxInFlight`
+// messages wait for their results, which pauses the partition when the writes fall behind.
+// It returns an error at the first message that can't be acknowledged: its write failed with a
+// retryable error or the dead letter topic didn't take it. No message after it is acknowledged,
+// so the partition must be consumed again from that message
+func (dk *DefaultKafka) process(messages <-chan *sarama.ConsumerMessage, handler ListenHandler, maxInFlight int, ack func(*sarama.ConsumerMessage)) error {
+	pending := make(chan inFlight, maxInFlight)
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	var failure error
+
+	go func() {
+		defer close(done)
+		for p := range pending {
+			if failure != nil {
+				continue
+			}
+			if failure = dk.settle(p); failure != nil {
+				close(stopped)
+				continue
+			}
+			ack(p.msg)
+		}
+	}()
+
+feed:
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				break feed
+			}
+			logrus.Debugf("Got message on topic (%s): %s", msg.Topic, msg.Value)
+			metrics.MessagesConsumed.WithLabelValues(msg.Topic).Inc()
+			select {
+			case pending <- inFlight{msg: msg, result: handler(newMessage(msg))}:
+			case <-stopped:
+				break feed
+			}
+		case <-stopped:
+			break feed
+		}
+	}
+
+	close(pending)
+	<-done
+	return failure
+}
+
+// settle waits for the result of a message, sending it to the dead letter topic when it was
+// rejected for good. It fails when the message can't be acknowledged
+func (dk *DefaultKafka) settle(p inFlight) error {
+	err := <-p.result
+	if err == nil {
+		return nil
+	}
+	if IsRetryable(err) {
+		return fmt.Errorf("Message not acknowledged. Topic: %s, Partition: %d, Offset: %d, Error: %v", p.msg.Topic, p.msg.Partition, p.msg.Offset, err)
+	}
+	if dlqErr := dk.deadLetter(p.msg, err); dlqErr != nil {
+		return fmt.Errorf("Message not acknowledged. Topic: %s, Partition: %d, Offset: %d, Error: %v", p.msg.Topic, p.msg.Partition, p.msg.Offset, dlqErr)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	"github.com/Shopify/sarama"
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+// failingAt fails the write of the message at the offset, accepting every other message
+func failingAt(offset int64) ListenHandler {
+	return func(msg *models.Message) <-chan error {
+		if msg.Offset == offset {
+			return Result(&WriteError{Err: errors.New("connection refused")})
+		}
+		return Result(nil)
+	}
+}
+
+func TestProcessStopsAcknowledgingAtFailedWrite(t *testing.T) {
+	messages := make(chan *sarama.ConsumerMessage, 5)
+	for offset := int64(0); offset < 5; offset++ {
+		messages <- &sarama.ConsumerMessage{Topic: "state", Offset: offset}
+	}
+	close(messages)
+
+	acked := []int64{}
+	err := newTestKafka(nil, 3).process(messages, failingAt(2), 2, func(msg *sarama.ConsumerMessage) {
+		acked = append(acked, msg.Offset)
+	})
+	assert.Error(t, err, "Offset: 2")
+	assert.DeepEqual(t, acked, []int64{0, 1})
+}
+
+func TestPartitionListenerReopensAtFailedWrite(t *testing.T) {
+	// the mock partition consumer yields messages from offset 1
+	seq, partitions := newConsumerSequence(t, 1, 2)
+	dk := newTestKafka(seq, 3)
+	for i := 0; i < 3; i++ {
+		partitions[0].YieldMessage(&sarama.ConsumerMessage{Value: []byte("state")})
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error)
+	listener := newPartitionListener(dk, failingAt(2), "state", 0, 1)
+	go func() {
+		done <- listener.run(stop)
+	}()
+
+	<-seq.opened
+	<-seq.opened
+	close(stop)
+	assert.NilError(t, <-done)
+	assert.Equal(t, listener.offset, int64(2))
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// BulkPoint is a state point of a bulk ingestion, carrying its own owner/thing/node tags
+type BulkPoint struct {
+	Tags     map[string]string      `json:"tags"`
+	DateTime time.Time              `json:"dateTime"`
+	Fields   map[string]interface{} `json:"fields"`
+}
+
+// BulkResult tells whether the point at Index of a bulk ingestion was accepted
+type BulkResult struct {
+	Index    int    `json:"index"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// BulkResponse sums up the results of a bulk ingestion
+type BulkResponse struct {
+	Accepted int          `json:"accepted"`
+	Rejected int          `json:"rejected"`
+	Results  []BulkResult `json:"results"`
+}
@@ -9,5 +9,6 @@ type Data struct {
 	StartDateTime time.Time
 	EndDateTime   time.Time
 	Tags          map[string]string
-	Fields        map[string]string
+	Fields        map[string]interface{}
+	Options       QueryOptions
 }
@@ -0,0 +1,14 @@
+package models
+
+// SeriesCount is the number of points of the series with the given tag set
+type SeriesCount struct {
+	Tags   map[string]string `json:"tags"`
+	Points int64             `json:"points"`
+}
+
+// DeleteResult tells how many points were deleted or, on a dry run, would be deleted
+type DeleteResult struct {
+	DryRun bool          `json:"dryRun"`
+	Points int64         `json:"points"`
+	Series []SeriesCount `json:"series"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// Message is a record consumed from a Kafka topic
+type Message struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+	Timestamp time.Time
+}
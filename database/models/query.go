@@ -0,0 +1,81 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// QueryOptions refines how the state points are retrieved. Pages hold up to `Limit` points
+// and continue from the `Cursor`, in ascending or descending time order
+type QueryOptions struct {
+	Aggregate  string
+	Fields     []string
+	GroupBy    string
+	Fill       string
+	Limit      int
+	Cursor     Cursor
+	Descending bool
+}
+
+// Cursor is where a page of points continues. Several nodes may have points at the same time,
+// so the page continues at the time of the last point, skipping the nodes already returned at
+// that time. A node has a single point at a given time
+type Cursor struct {
+	Time time.Time   `json:"time"`
+	Seen [][3]string `json:"seen"`
+}
+
+// NextCursor is the cursor of the page following the points, read from the previous cursor
+func NextCursor(previous Cursor, points []StatePoint) Cursor {
+	last := points[len(points)-1].DateTime
+	next := Cursor{Time: last}
+	if previous.Time.Equal(last) {
+		next.Seen = append(next.Seen, previous.Seen...)
+	}
+	for _, point := range points {
+		if point.DateTime.Equal(last) {
+			next.Seen = append(next.Seen, point.node())
+		}
+	}
+	return next
+}
+
+// ParseCursor reads a cursor encoded by String
+func ParseCursor(token string) (Cursor, error) {
+	var cursor Cursor
+	content, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(content, &cursor)
+	}
+	if err == nil && cursor.IsZero() {
+		err = fmt.Errorf("no time")
+	}
+	return cursor, err
+}
+
+// String encodes the cursor as an opaque token that can be sent as a query param
+func (c Cursor) String() string {
+	content, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+// IsZero tells whether the cursor is missing, so the page starts at the beginning
+func (c Cursor) IsZero() bool {
+	return c.Time.IsZero()
+}
+
+// Skips tells whether the point was returned by a previous page
+func (c Cursor) Skips(point StatePoint) bool {
+	if !point.DateTime.Equal(c.Time) {
+		return false
+	}
+	node := point.node()
+	for _, seen := range c.Seen {
+		if seen == node {
+			return true
+		}
+	}
+	return false
+}
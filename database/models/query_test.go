@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestNextCursorSkipsTheNodesReturnedAtTheLastTime(t *testing.T) {
+	at := time.Date(2020, time.April, 8, 0, 4, 8, 0, time.UTC)
+	point := func(thing string, dateTime time.Time) StatePoint {
+		return StatePoint{Owner: "movbb", Thing: thing, Node: "location", DateTime: dateTime}
+	}
+
+	first := NextCursor(Cursor{}, []StatePoint{point("1", at.Add(-time.Second)), point("1", at), point("2", at)})
+	assert.Equal(t, first.Time, at)
+	assert.DeepEqual(t, first.Seen, [][3]string{{"movbb", "1", "location"}, {"movbb", "2", "location"}})
+
+	// a page at the same time as the cursor keeps the nodes of the previous pages
+	second := NextCursor(first, []StatePoint{point("3", at)})
+	assert.Equal(t, len(second.Seen), 3)
+	assert.Equal(t, second.Skips(point("2", at)), true)
+	assert.Equal(t, second.Skips(point("4", at)), false)
+	assert.Equal(t, second.Skips(point("2", at.Add(time.Second))), false)
+
+	parsed, err := ParseCursor(second.String())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, parsed, second)
+
+	_, err = ParseCursor("2020-04-08T00:04:08Z")
+	assert.NotNil(t, err)
+	_, err = ParseCursor(Cursor{}.String())
+	assert.NotNil(t, err)
+}
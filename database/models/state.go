@@ -4,11 +4,19 @@ import (
 	"time"
 )
 
-// StatePoint addresses a ower/thing/node state representation
+// StatePoint addresses a ower/thing/node state representation. Tags holds the tag set of
+// the series the point was read from, and Static the static attributes of the node when joined
 type StatePoint struct {
-	Owner      string            `json:"owner"`
-	Thing      string            `json:"thing"`
-	Node       string            `json:"node"`
-	Attributes map[string]string `json:"attributes"`
-	DateTime   time.Time         `json:"dateTime"`
+	Owner      string                 `json:"owner"`
+	Thing      string                 `json:"thing"`
+	Node       string                 `json:"node"`
+	Tags       map[string]string      `json:"tags,omitempty"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Static     map[string]interface{} `json:"static,omitempty"`
+	DateTime   time.Time              `json:"dateTime"`
+}
+
+// node identifies the owner/thing/node of the point
+func (p StatePoint) node() [3]string {
+	return [3]string{p.Owner, p.Thing, p.Node}
 }
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// permanentWriteErrors are the InfluxDB write error messages returned with a 4xx status.
+// Writing the same points again will never succeed
+var permanentWriteErrors = []string{
+	"field type conflict",
+	"partial write",
+	"unable to parse",
+	"invalid field format",
+	"invalid tag format",
+	"bad timestamp",
+	"points beyond retention policy",
+	"database not found",
+	"retention policy not found",
+	"authorization failed",
+	"max-values-per-tag limit exceeded",
+	"max-series-per-database limit exceeded",
+}
+
+// WriteError is returned when InfluxDB answers a write request with an error
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error returned by the InfluxDB client
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable tells whether a failed write may succeed if done again. Network errors and
+// server side failures are retryable, while rejected points are not
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var writeErr *WriteError
+	if errors.As(err, &writeErr) {
+		msg := strings.ToLower(writeErr.Error())
+		for _, permanent := range permanentWriteErrors {
+			if strings.Contains(msg, permanent) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// RetryPolicy defines how many times and how often a failed write is retried. Without
+// MaxAttempts, retryable failures are retried until the writer stops
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+// NewRetryPolicy initializes a retry policy from web builder
+func NewRetryPolicy(webBuilder *config.WebBuilder) *RetryPolicy {
+	policy := new(RetryPolicy)
+	policy.MaxAttempts = webBuilder.RetryMaxAttempts
+	policy.BaseDelay = webBuilder.RetryBaseDelay
+	policy.MaxDelay = webBuilder.RetryMaxDelay
+	policy.Jitter = webBuilder.RetryJitter
+
+	return policy
+}
+
+// Backoff returns how long to wait before the given attempt (starting at 1). The delay doubles
+// at every attempt up to the max delay and is randomly reduced by up to the jitter fraction
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	delay = math.Min(delay, math.MaxInt64)
+	if p.Jitter > 0 {
+		delay -= delay * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(delay)
+}
+
+// Do runs the operation until it succeeds, fails with a permanent error, the attempts run out or
+// stop is closed, returning the last error. It blocks while waiting, so the caller is paused in
+// the meantime
+func (p *RetryPolicy) Do(stop <-chan struct{}, operation func() error) (err error) {
+	for attempt := 1; ; attempt++ {
+		if err = operation(); err == nil || !IsRetryable(err) || (p.MaxAttempts > 0 && attempt >= p.MaxAttempts) {
+			return
+		}
+
+		delay := p.Backoff(attempt)
+		logrus.Warnf("Attempt %d failed, retrying in %s: %v", attempt, delay, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://influxdb:8086/write", Err: syscall.ECONNREFUSED}
+	assert.Equal(t, IsRetryable(fmt.Errorf("Details: %w", &WriteError{Err: refused})), true)
+	assert.Equal(t, IsRetryable(&WriteError{Err: errors.New(`{"error":"timeout"}`)}), true)
+	assert.Equal(t, IsRetryable(&WriteError{Err: errors.New("<html>502 Bad Gateway</html>")}), true)
+	assert.Equal(t, IsRetryable(&WriteError{Err: errors.New("<html>502 Bad Gateway: invalid response from upstream</html>")}), true)
+
+	assert.Equal(t, IsRetryable(&WriteError{Err: errors.New(`{"error":"partial write: field type conflict: input field \"lat\" on measurement \"state\" is type float, already exists as type string dropped=1"}`)}), false)
+	assert.Equal(t, IsRetryable(&WriteError{Err: errors.New(`{"error":"invalid field format"}`)}), false)
+	assert.Equal(t, IsRetryable(errors.New("Static attributes must be saved in the static data DataBase")), false)
+	assert.Equal(t, IsRetryable(nil), false)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	assert.Equal(t, policy.Backoff(1), 100*time.Millisecond)
+	assert.Equal(t, policy.Backoff(3), 400*time.Millisecond)
+	assert.Equal(t, policy.Backoff(10), time.Second)
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(2)
+		assert.Equal(t, delay >= 100*time.Millisecond && delay <= 200*time.Millisecond, true)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	attempts := 0
+	err := policy.Do(nil, func() error {
+		attempts++
+		return &WriteError{Err: errors.New("timeout")}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, attempts, 3)
+
+	attempts = 0
+	err = policy.Do(nil, func() error {
+		attempts++
+		return &WriteError{Err: errors.New("field type conflict")}
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, attempts, 1)
+
+	attempts = 0
+	err = policy.Do(nil, func() error {
+		if attempts++; attempts < 2 {
+			return &WriteError{Err: errors.New("timeout")}
+		}
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, attempts, 2)
+}
+
+func TestRetryPolicyDoRetriesUntilStopped(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	stop := make(chan struct{})
+
+	attempts := 0
+	err := policy.Do(stop, func() error {
+		if attempts++; attempts == 20 {
+			close(stop)
+		}
+		return &WriteError{Err: errors.New("timeout")}
+	})
+	assert.Error(t, err, "timeout")
+	assert.Equal(t, attempts, 20)
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// staticBucket holds the static attributes, keyed by owner/thing/node
+var staticBucket = []byte("static")
+
+// StaticDatabase defines the methods to persist the static attributes (aka the ones prefixed
+// with `$`) of every owner/thing/node. They don't change over time, so they are kept apart
+// from the state points
+type StaticDatabase interface {
+	Init(webBuilder *config.WebBuilder) StaticDatabase
+	Connect() StaticDatabase
+	Close() error
+	GetAttributes(owner string, thing string, node string) (map[string]interface{}, error)
+	UpdateAttributes(owner string, thing string, node string, update func(attributes map[string]interface{}) error) (map[string]interface{}, error)
+	DeleteAttributes(owner string, thing string, node string) error
+}
+
+// DefaultStaticDatabase a StaticDatabase implementation on an embedded bolt file
+type DefaultStaticDatabase struct {
+	Path string
+	DB   *bolt.DB
+}
+
+// Init initializes the static database from web builder
+func (db *DefaultStaticDatabase) Init(webBuilder *config.WebBuilder) StaticDatabase {
+	db.Path = webBuilder.StaticDBPath
+	return db
+}
+
+// Connect opens the bolt file, failing when another process holds it
+func (db *DefaultStaticDatabase) Connect() StaticDatabase {
+	logrus.Debugf("Opening static database %s", db.Path)
+
+	boltDB, err := bolt.Open(db.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err == nil {
+		err = boltDB.Update(func(tx *bolt.Tx) error {
+			_, err := tx.CreateBucketIfNotExists(staticBucket)
+			return err
+		})
+	}
+	if err != nil {
+		logrus.Errorf("error opening static database: %s", err)
+		panic(fmt.Sprintf("No error should happen when opening the static database %s, but got err=%+v", db.Path, err))
+	}
+
+	db.DB = boltDB
+	return db
+}
+
+// Close closes the bolt file
+func (db *DefaultStaticDatabase) Close() error {
+	return db.DB.Close()
+}
+
+// GetAttributes gets the static attributes of a node, empty when none was saved
+func (db *DefaultStaticDatabase) GetAttributes(owner string, thing string, node string) (attributes map[string]interface{}, err error) {
+	err = db.DB.View(func(tx *bolt.Tx) error {
+		attributes, err = readAttributes(tx, staticKey(owner, thing, node))
+		return err
+	})
+	return
+}
+
+// UpdateAttributes changes the static attributes of a node in a single transaction and returns
+// the saved ones. Nothing is saved when update fails
+func (db *DefaultStaticDatabase) UpdateAttributes(owner string, thing string, node string, update func(attributes map[string]interface{}) error) (attributes map[string]interface{}, err error) {
+	key := staticKey(owner, thing, node)
+	err = db.DB.Update(func(tx *bolt.Tx) error {
+		if attributes, err = readAttributes(tx, key); err != nil {
+			return err
+		}
+		if err = update(attributes); err != nil {
+			return err
+		}
+
+		if len(attributes) == 0 {
+			return tx.Bucket(staticBucket).Delete(key)
+		}
+		value, err := json.Marshal(attributes)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(staticBucket).Put(key, value)
+	})
+	return
+}
+
+// DeleteAttributes removes every static attribute of a node
+func (db *DefaultStaticDatabase) DeleteAttributes(owner string, thing string, node string) error {
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(staticBucket).Delete(staticKey(owner, thing, node))
+	})
+}
+
+// staticKey joins the tags with a separator that can't be part of an URL path param
+func staticKey(owner string, thing string, node string) []byte {
+	return []byte(strings.Join([]string{owner, thing, node}, "\x00"))
+}
+
+func readAttributes(tx *bolt.Tx, key []byte) (map[string]interface{}, error) {
+	attributes := map[string]interface{}{}
+	if value := tx.Bucket(staticBucket).Get(key); value != nil {
+		if err := json.Unmarshal(value, &attributes); err != nil {
+			return nil, fmt.Errorf("Error reading static attributes. Details: %s", err)
+		}
+	}
+	return attributes, nil
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestStaticDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := new(DefaultStaticDatabase).Init(&config.WebBuilder{Flags: &config.Flags{StaticDBPath: filepath.Join(dir, "static.db")}}).Connect()
+	defer db.Close()
+
+	attributes, err := db.GetAttributes("movbb", "297145674599", "location")
+	assert.NilError(t, err)
+	assert.Equal(t, len(attributes), 0)
+
+	attributes, err = db.UpdateAttributes("movbb", "297145674599", "location", func(attributes map[string]interface{}) error {
+		attributes["$name"] = "truck 42"
+		attributes["$unit"] = "degrees"
+		return nil
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(attributes), 2)
+
+	// a failed update saves nothing
+	_, err = db.UpdateAttributes("movbb", "297145674599", "location", func(attributes map[string]interface{}) error {
+		delete(attributes, "$name")
+		return errors.New("invalid")
+	})
+	assert.Error(t, err, "invalid")
+
+	attributes, err = db.GetAttributes("movbb", "297145674599", "location")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, attributes, map[string]interface{}{"$name": "truck 42", "$unit": "degrees"})
+
+	// the nodes don't share attributes
+	attributes, err = db.GetAttributes("movbb", "297145674599", "battery")
+	assert.NilError(t, err)
+	assert.Equal(t, len(attributes), 0)
+
+	assert.NilError(t, db.DeleteAttributes("movbb", "297145674599", "location"))
+	attributes, err = db.GetAttributes("movbb", "297145674599", "location")
+	assert.NilError(t, err)
+	assert.Equal(t, len(attributes), 0)
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	client "github.com/influxdata/influxdb1-client/v2"
+	"github.com/sirupsen/logrus"
+)
+
+// pendingPoint is a point waiting for its batch to be written
+type pendingPoint struct {
+	point  *client.Point
+	result chan error
+}
+
+// BatchWriter buffers points and writes them to Influx in batches, flushing when the batch
+// is full or the flush interval elapses. It is safe for concurrent use
+type BatchWriter struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	db            Database
+	retry         *RetryPolicy
+	queue         chan *pendingPoint
+	done          chan struct{}
+	stopping      chan struct{}
+	stop          sync.Once
+	lock          sync.RWMutex
+	closed        bool
+}
+
+// NewBatchWriter initializes a batch writer from web builder and starts flushing in background
+func NewBatchWriter(webBuilder *config.WebBuilder, db Database, retry *RetryPolicy) *BatchWriter {
+	instance := new(BatchWriter)
+	instance.BatchSize = webBuilder.BatchSize
+	instance.FlushInterval = webBuilder.FlushInterval
+	if instance.BatchSize <= 0 {
+		instance.BatchSize = 1
+	}
+	if instance.FlushInterval <= 0 {
+		instance.FlushInterval = time.Second
+	}
+	instance.db = db
+	instance.retry = retry
+	instance.queue = make(chan *pendingPoint, instance.BatchSize)
+	instance.done = make(chan struct{})
+	instance.stopping = make(chan struct{})
+
+	go instance.run()
+
+	return instance
+}
+
+// Result returns a result channel already holding the given outcome
+func Result(err error) <-chan error {
+	result := make(chan error, 1)
+	result <- err
+	return result
+}
+
+// Write queues a point to be written. It blocks while the queue is full, so callers are paused
+// when Influx is slow or unavailable. The returned channel yields the write result once the
+// batch holding the point was flushed
+func (w *BatchWriter) Write(point *client.Point) <-chan error {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	if w.closed {
+		return Result(fmt.Errorf("The writer is closed. Point not written: %s", point))
+	}
+
+	pending := &pendingPoint{point: point, result: make(chan error, 1)}
+	w.queue <- pending
+	return pending.result
+}
+
+// Close flushes the queued points and stops the writer. The writes failing by then aren't
+// retried anymore, which also releases the callers blocked in Write
+func (w *BatchWriter) Close() error {
+	w.stop.Do(func() {
+		close(w.stopping)
+	})
+
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.lock.Unlock()
+
+	<-w.done
+	return nil
+}
+
+func (w *BatchWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*pendingPoint, 0, w.BatchSize)
+	for {
+		select {
+		case pending, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, pending)
+			if len(batch) >= w.BatchSize {
+				w.flush(batch)
+				batch = make([]*pendingPoint, 0, w.BatchSize)
+			}
+
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = make([]*pendingPoint, 0, w.BatchSize)
+			}
+		}
+	}
+}
+
+// flush writes a batch and hands the result to every point in it
+func (w *BatchWriter) flush(batch []*pendingPoint) {
+	if len(batch) == 0 {
+		return
+	}
+
+	points := make([]*client.Point, len(batch))
+	for i, pending := range batch {
+		points[i] = pending.point
+	}
+
+	start := time.Now()
+	err := w.retry.Do(w.stopping, func() error {
+		return w.db.WritePoints(points)
+	})
+	logrus.Debugf("Flushed %d points in %s", len(points), time.Since(start))
+
+	// a single rejected point fails the whole batch, so the points are written
+	// one by one to tell the rejected ones apart
+	if err != nil && !IsRetryable(err) && len(batch) > 1 {
+		logrus.Warnf("Batch of %d points rejected, writing them one by one: %v", len(batch), err)
+		for _, pending := range batch {
+			point := pending.point
+			pending.result <- w.retry.Do(w.stopping, func() error {
+				return w.db.WritePoints([]*client.Point{point})
+			})
+		}
+		return
+	}
+
+	for _, pending := range batch {
+		pending.result <- err
+	}
+}
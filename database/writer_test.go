@@ -0,0 +1,106 @@
+package database
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// fakeDatabase records the batches written and rejects the points of the `rejected` thing
+type fakeDatabase struct {
+	DefaultDatabase
+	lock    sync.Mutex
+	batches [][]*client.Point
+}
+
+func (db *fakeDatabase) WritePoints(points []*client.Point) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	for _, p := range points {
+		if p.Tags()["thing"] == "rejected" {
+			return &WriteError{Err: errors.New(`{"error":"partial write: field type conflict"}`)}
+		}
+	}
+	db.batches = append(db.batches, points)
+	return nil
+}
+
+func newTestPoint(t *testing.T, thing string) *client.Point {
+	point, err := new(DefaultDatabase).NewPoint(&models.Data{
+		DateTime: time.Now(),
+		Tags:     map[string]string{"owner": "movbb", "thing": thing, "node": "location"},
+		Fields:   map[string]interface{}{"lat": -5.52},
+	})
+	assert.NilError(t, err)
+	return point
+}
+
+func newTestWriter(db Database, batchSize int) *BatchWriter {
+	return NewBatchWriter(&config.WebBuilder{Flags: &config.Flags{BatchSize: batchSize, FlushInterval: time.Hour}}, db, &RetryPolicy{MaxAttempts: 1})
+}
+
+func TestBatchWriterFlushesFullBatches(t *testing.T) {
+	db := new(fakeDatabase)
+	writer := newTestWriter(db, 2)
+
+	results := []<-chan error{}
+	for i := 0; i < 3; i++ {
+		results = append(results, writer.Write(newTestPoint(t, "abc1234")))
+	}
+	assert.NilError(t, <-results[0])
+	assert.NilError(t, <-results[1])
+	assert.Equal(t, len(db.batches), 1)
+
+	// the incomplete batch is flushed when closing
+	assert.NilError(t, writer.Close())
+	assert.NilError(t, <-results[2])
+	assert.Equal(t, len(db.batches), 2)
+
+	assert.NotNil(t, <-writer.Write(newTestPoint(t, "abc1234")))
+}
+
+func TestBatchWriterIsolatesRejectedPoints(t *testing.T) {
+	db := new(fakeDatabase)
+	writer := newTestWriter(db, 3)
+
+	accepted := writer.Write(newTestPoint(t, "abc1234"))
+	rejected := writer.Write(newTestPoint(t, "rejected"))
+	other := writer.Write(newTestPoint(t, "def5678"))
+
+	assert.NilError(t, <-accepted)
+	assert.Error(t, <-rejected, "field type conflict")
+	assert.NilError(t, <-other)
+	assert.NilError(t, writer.Close())
+}
+
+// unreachableDatabase fails every write as if InfluxDB was down
+type unreachableDatabase struct {
+	DefaultDatabase
+}
+
+func (db *unreachableDatabase) WritePoints(points []*client.Point) error {
+	return &WriteError{Err: errors.New(`{"error":"timeout"}`)}
+}
+
+func TestBatchWriterRetriesUntilClosed(t *testing.T) {
+	writer := NewBatchWriter(&config.WebBuilder{Flags: &config.Flags{BatchSize: 1, FlushInterval: time.Hour}}, new(unreachableDatabase), &RetryPolicy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	result := writer.Write(newTestPoint(t, "abc1234"))
+	select {
+	case <-result:
+		t.Fatal("The write must be retried while the writer runs")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.NilError(t, writer.Close())
+	err := <-result
+	assert.Equal(t, IsRetryable(err), true)
+}
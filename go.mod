@@ -7,10 +7,10 @@ require (
 	github.com/docker/docker v1.13.1
 	github.com/gin-gonic/gin v1.6.2
 	github.com/hamba/avro v1.0.0
-	github.com/huandu/go-sqlbuilder v1.7.0
 	github.com/influxdata/influxdb1-client v0.0.0-20191209144304-8bf82d3c094d
 	github.com/jarcoal/httpmock v1.0.5 // indirect
 	github.com/pelletier/go-toml v1.4.0 // indirect
+	github.com/prometheus/client_golang v1.6.0
 	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/afero v1.2.2 // indirect
 	github.com/spf13/cobra v1.0.0
@@ -19,5 +19,7 @@ require (
 	github.com/spf13/viper v1.6.3
 	github.com/valyala/fasthttp v1.12.0
 	github.com/wailsapp/wails v1.5.0 // indirect
+	go.etcd.io/bbolt v1.3.4
 	gopkg.in/jcmturner/goidentity.v3 v3.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
@@ -12,10 +13,32 @@ const (
 	kafkaAddr           = "kafka-addr"
 	kafkaTopic          = "kafka-topic"
 	kafkaSchemaRegistry = "kafka-schema-registry"
+	kafkaGroupID        = "kafka-group-id"
+	kafkaStartFrom      = "kafka-start-from"
+	kafkaDLQTopic       = "kafka-dlq-topic"
+	kafkaKeyPattern     = "kafka-key-pattern"
+	kafkaHeaderTags     = "kafka-header-tags"
+	kafkaMaxErrors      = "kafka-max-errors"
+	kafkaRetryBaseDelay = "kafka-retry-base-delay"
+	kafkaRetryMaxDelay  = "kafka-retry-max-delay"
+	kafkaExitOnFailure  = "kafka-exit-on-failure"
 	influxdbAddr        = "influxdb-addr"
 	influxdbName        = "influxdb-name"
 	influxdbUser        = "influxdb-user"
 	influxdbPassword    = "influxdb-password"
+	batchSize           = "influxdb-batch-size"
+	flushInterval       = "influxdb-flush-interval"
+	retryMaxAttempts    = "influxdb-retry-max-attempts"
+	retryBaseDelay      = "influxdb-retry-base-delay"
+	retryMaxDelay       = "influxdb-retry-max-delay"
+	retryJitter         = "influxdb-retry-jitter"
+	mappingConfig       = "mapping-config"
+	maxRows             = "max-rows"
+	maxQueryPeriod      = "max-query-period"
+	staticDBPath        = "static-db-path"
+	streamBuffer        = "stream-buffer"
+	healthCheckTimeout  = "health-check-timeout"
+	shutdownGracePeriod = "shutdown-grace-period"
 	port                = "port"
 	logLevel            = "log-level"
 	withSASL            = "with-sasl"
@@ -31,10 +54,32 @@ type Flags struct {
 	KafkaAddr           string
 	KafkaTopic          string
 	KafkaSchemaRegistry string
+	KafkaGroupID        string
+	KafkaStartFrom      string
+	KafkaDLQTopic       string
+	KafkaKeyPattern     string
+	KafkaHeaderTags     string
+	KafkaMaxErrors      int
+	KafkaRetryBaseDelay time.Duration
+	KafkaRetryMaxDelay  time.Duration
+	KafkaExitOnFailure  bool
 	InfluxdbName        string
 	InfluxdbAddr        string
 	InfluxdbUser        string
 	InfluxdbPassword    string
+	BatchSize           int
+	FlushInterval       time.Duration
+	RetryMaxAttempts    int
+	RetryBaseDelay      time.Duration
+	RetryMaxDelay       time.Duration
+	RetryJitter         float64
+	MappingConfig       string
+	MaxRows             int
+	MaxQueryPeriod      time.Duration
+	StaticDBPath        string
+	StreamBuffer        int
+	HealthCheckTimeout  time.Duration
+	ShutdownGracePeriod time.Duration
 	Port                string
 	LogLevel            string
 	WithSASL            bool
@@ -48,6 +93,7 @@ type Flags struct {
 // WebBuilder defines the parametric information of a server instance
 type WebBuilder struct {
 	*Flags
+	Mappings *MappingConfig
 }
 
 // AddFlags adds flags for Builder.
@@ -55,10 +101,32 @@ func AddFlags(flags *pflag.FlagSet) {
 	flags.StringP(kafkaAddr, "k", "", "Kafka URL")
 	flags.StringP(kafkaTopic, "t", "/owner/*", "Kafka's topic")
 	flags.StringP(kafkaSchemaRegistry, "e", "", "Kafka's schema registry")
+	flags.String(kafkaGroupID, "", "[optional] Kafka consumer group ID. When set, partitions are balanced across replicas and offsets are committed")
+	flags.String(kafkaStartFrom, "oldest", "[optional] Where partitions without committed offsets start: oldest, newest, a RFC3339 timestamp or [topic:]partition=offset pairs. Default: oldest")
+	flags.String(kafkaDLQTopic, "", "[optional] Kafka topic receiving the messages that could not be persisted")
+	flags.String(kafkaKeyPattern, "owner/{owner}/thing/{thing}/node/{node}", "[optional] Message key template (Ex: tenant/{tenant}/device/{device}) or regex with named groups. Every captured part becomes a tag")
+	flags.String(kafkaHeaderTags, "", "[optional] Comma separated Kafka headers that become tags (Ex: owner,thing,node)")
+	flags.Int(kafkaMaxErrors, 10, "[optional] Consecutive errors of a partition before the consumer gives up. 0 never gives up. Default: 10")
+	flags.Duration(kafkaRetryBaseDelay, time.Second, "[optional] Delay before reopening a failed partition, doubled at every consecutive error. Default: 1s")
+	flags.Duration(kafkaRetryMaxDelay, time.Minute, "[optional] Max delay before reopening a failed partition. Default: 1m")
+	flags.Bool(kafkaExitOnFailure, false, "[optional] Exit when the consumer gives up, instead of only failing the readiness check. Default: false")
 	flags.StringP(influxdbAddr, "i", "", "InfluxDB URL")
 	flags.StringP(influxdbName, "n", "interactws", "[optional] Sets the InfluxDB's name. Default: 'interactws'")
 	flags.StringP(influxdbUser, "u", "", "Sets the InfluxDB's user")
 	flags.StringP(influxdbPassword, "s", "", "Sets the InfluxDB's password")
+	flags.Int(batchSize, 1000, "[optional] Max points written to InfluxDB in a single request. Default: 1000")
+	flags.Duration(flushInterval, 500*time.Millisecond, "[optional] Max time a point waits for its batch to be written to InfluxDB. Default: 500ms")
+	flags.Int(retryMaxAttempts, 0, "[optional] Max attempts of an InfluxDB write failing with a network or server error before giving up. 0 retries until shutdown. Default: 0")
+	flags.Duration(retryBaseDelay, 200*time.Millisecond, "[optional] Delay before retrying a failed InfluxDB write, doubled at every attempt. Default: 200ms")
+	flags.Duration(retryMaxDelay, 30*time.Second, "[optional] Max delay between InfluxDB write attempts. Default: 30s")
+	flags.Float64(retryJitter, 0.2, "[optional] Fraction of the retry delay randomly subtracted to spread retries. Default: 0.2")
+	flags.String(mappingConfig, "", "[optional] JSON or YAML file mapping the record attributes to InfluxDB tags and fields per topic or schema")
+	flags.Int(maxRows, 10000, "[optional] Max points returned by a single query. Larger results must be paged with the `limit` and `cursor` params. Default: 10000")
+	flags.Duration(maxQueryPeriod, 0, "[optional] Max time range of a query. Longer and open-ended ranges are rejected. Default: 0 (unlimited)")
+	flags.String(staticDBPath, "static.db", "[optional] File of the embedded database keeping the static attributes (prefixed with `$`). Default: static.db")
+	flags.Int(streamBuffer, 100, "[optional] Points buffered for each live stream subscriber before dropping the new ones. Default: 100")
+	flags.Duration(healthCheckTimeout, time.Second, "[optional] Max time each readiness check of a dependency can take. Default: 1s")
+	flags.Duration(shutdownGracePeriod, 25*time.Second, "[optional] Max time to drain the consumed messages, flush the writes and finish the HTTP requests on shutdown. Default: 25s")
 	flags.StringP(port, "p", "7070", "[optional] Custom port for accessing Kafka2InfluxDB's services. Default: 7070")
 	flags.StringP(logLevel, "l", "info", "[optional] Sets the Log Level to one of seven (trace, debug, info, warn, error, fatal, panic). Default: info")
 	flags.StringP(withSASL, "w", "false", "[optional] Enable/Disable SASL Kafka Security. Default: false")
@@ -75,10 +143,32 @@ func (b *WebBuilder) Init(v *viper.Viper) *WebBuilder {
 	flags.KafkaAddr = v.GetString(kafkaAddr)
 	flags.KafkaTopic = v.GetString(kafkaTopic)
 	flags.KafkaSchemaRegistry = v.GetString(kafkaSchemaRegistry)
+	flags.KafkaGroupID = v.GetString(kafkaGroupID)
+	flags.KafkaStartFrom = v.GetString(kafkaStartFrom)
+	flags.KafkaDLQTopic = v.GetString(kafkaDLQTopic)
+	flags.KafkaKeyPattern = v.GetString(kafkaKeyPattern)
+	flags.KafkaHeaderTags = v.GetString(kafkaHeaderTags)
+	flags.KafkaMaxErrors = v.GetInt(kafkaMaxErrors)
+	flags.KafkaRetryBaseDelay = v.GetDuration(kafkaRetryBaseDelay)
+	flags.KafkaRetryMaxDelay = v.GetDuration(kafkaRetryMaxDelay)
+	flags.KafkaExitOnFailure = v.GetBool(kafkaExitOnFailure)
 	flags.InfluxdbAddr = v.GetString(influxdbAddr)
 	flags.InfluxdbName = v.GetString(influxdbName)
 	flags.InfluxdbUser = v.GetString(influxdbUser)
 	flags.InfluxdbPassword = v.GetString(influxdbPassword)
+	flags.BatchSize = v.GetInt(batchSize)
+	flags.FlushInterval = v.GetDuration(flushInterval)
+	flags.RetryMaxAttempts = v.GetInt(retryMaxAttempts)
+	flags.RetryBaseDelay = v.GetDuration(retryBaseDelay)
+	flags.RetryMaxDelay = v.GetDuration(retryMaxDelay)
+	flags.RetryJitter = v.GetFloat64(retryJitter)
+	flags.MappingConfig = v.GetString(mappingConfig)
+	flags.MaxRows = v.GetInt(maxRows)
+	flags.MaxQueryPeriod = v.GetDuration(maxQueryPeriod)
+	flags.StaticDBPath = v.GetString(staticDBPath)
+	flags.StreamBuffer = v.GetInt(streamBuffer)
+	flags.HealthCheckTimeout = v.GetDuration(healthCheckTimeout)
+	flags.ShutdownGracePeriod = v.GetDuration(shutdownGracePeriod)
 	flags.Port = v.GetString(port)
 	flags.LogLevel = v.GetString(logLevel)
 	flags.WithSASL = v.GetBool(withSASL)
@@ -89,7 +179,13 @@ func (b *WebBuilder) Init(v *viper.Viper) *WebBuilder {
 	flags.KerberosRealm = v.GetString(kerberosRealm)
 	flags.check()
 
+	mappings, err := LoadMappingConfig(flags.MappingConfig)
+	if err != nil {
+		panic(err.Error())
+	}
+
 	b.Flags = flags
+	b.Mappings = mappings
 
 	return b
 }
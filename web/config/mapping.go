@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Mapping defines how the paths of a decoded record become a state point. Paths are dot
+// separated to reach nested records (Ex: "position.lat"). When no field is listed, every
+// top-level attribute that is not a tag, the timestamp or dropped becomes a field.
+// Coerce converts fields, by name, to one of `float`, `integer`, `boolean` or `string`
+type Mapping struct {
+	Tags      []string          `json:"tags" yaml:"tags"`
+	Fields    []string          `json:"fields" yaml:"fields"`
+	Timestamp string            `json:"timestamp" yaml:"timestamp"`
+	Drop      []string          `json:"drop" yaml:"drop"`
+	Coerce    map[string]string `json:"coerce" yaml:"coerce"`
+}
+
+// MappingConfig holds the mapping of every topic and schema. The topic mapping has precedence
+// over the schema one, and the default mapping is used when none of them is found
+type MappingConfig struct {
+	Default Mapping            `json:"default" yaml:"default"`
+	Topics  map[string]Mapping `json:"topics" yaml:"topics"`
+	Schemas map[string]Mapping `json:"schemas" yaml:"schemas"`
+}
+
+// ValidCoercions are the types a field can be converted to
+var ValidCoercions = map[string]bool{"float": true, "integer": true, "boolean": true, "string": true}
+
+// DefaultMappingConfig keeps every top-level attribute, taking the timestamp from `dateTime`
+func DefaultMappingConfig() *MappingConfig {
+	return &MappingConfig{Default: Mapping{Timestamp: "dateTime"}}
+}
+
+// LoadMappingConfig reads the mapping configuration from a JSON or YAML file
+func LoadMappingConfig(path string) (*MappingConfig, error) {
+	mappings := DefaultMappingConfig()
+	if path == "" {
+		return mappings, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading mapping config %s: %s", path, err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".json":
+		err = json.Unmarshal(content, mappings)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, mappings)
+	default:
+		return nil, fmt.Errorf("The mapping config must be a .json, .yaml or .yml file. Got: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing mapping config %s: %s", path, err)
+	}
+
+	if mappings.Default.Timestamp == "" {
+		mappings.Default.Timestamp = "dateTime"
+	}
+
+	if err = mappings.Default.check(); err != nil {
+		return nil, err
+	}
+	for _, group := range []map[string]Mapping{mappings.Topics, mappings.Schemas} {
+		for _, mapping := range group {
+			if err = mapping.check(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return mappings, nil
+}
+
+// Lookup returns the mapping of a topic or schema, falling back to the default one
+func (m *MappingConfig) Lookup(topic string, schema string) Mapping {
+	mapping, ok := m.Topics[topic]
+	if !ok {
+		if mapping, ok = m.Schemas[schema]; !ok {
+			return m.Default
+		}
+	}
+
+	if mapping.Timestamp == "" {
+		mapping.Timestamp = m.Default.Timestamp
+	}
+	return mapping
+}
+
+func (m Mapping) check() error {
+	for field, kind := range m.Coerce {
+		if !ValidCoercions[kind] {
+			return fmt.Errorf("Invalid coercion `%s` of field `%s` in mapping config. Use one of float, integer, boolean or string", kind, field)
+		}
+	}
+	return nil
+}
@@ -1,17 +1,24 @@
 package controllers
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/labbsr0x/kafka2influxdb/database"
 	"github.com/labbsr0x/kafka2influxdb/database/models"
 	"github.com/labbsr0x/kafka2influxdb/web/config"
+	"github.com/labbsr0x/kafka2influxdb/web/metrics"
 	"github.com/labbsr0x/kafka2influxdb/web/services"
 	"github.com/labbsr0x/kafka2influxdb/web/utils"
 
@@ -20,40 +27,89 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// streamHeartbeat is the interval of the ping events of an idle stream
+const streamHeartbeat = 15 * time.Second
+
 type ConsumerController struct {
 	*config.WebBuilder
-	service      *services.ConsumerService
-	kafkaService *services.KafkaService
+	service        *services.ConsumerService
+	kafkaService   *services.KafkaService
+	mappingService *services.MappingService
+	periods        *utils.PeriodParser
+	staticService  *services.StaticService
 }
 
 func NewConsumerController(webBuilder *config.WebBuilder) *ConsumerController {
 	instance := new(ConsumerController)
+	instance.WebBuilder = webBuilder
 	instance.service = services.NewConsumerService(webBuilder)
 	instance.kafkaService = services.NewKafkaService(webBuilder)
+	instance.mappingService = services.NewMappingService(webBuilder)
+	instance.periods = &utils.PeriodParser{MaxPeriod: webBuilder.MaxQueryPeriod}
 	return instance
 }
 
-// ListenHandler saves a single node on influxdb
-func (c *ConsumerController) ListenHandler(key []byte, payload []byte) error {
-	data, err := c.getData(key, payload)
+// JoinStatic lets the query endpoints join the static attributes into the points when the
+// `static` param is true
+func (c *ConsumerController) JoinStatic(service *services.StaticService) *ConsumerController {
+	c.staticService = service
+	return c
+}
+
+// PingDatabase checks that InfluxDB answers within the timeout
+func (c *ConsumerController) PingDatabase(timeout time.Duration) error {
+	return c.service.PingDatabase(timeout)
+}
+
+// PingSchemaRegistry checks that the schema registry answers within the timeout
+func (c *ConsumerController) PingSchemaRegistry(timeout time.Duration) error {
+	return c.kafkaService.PingRegistry(timeout)
+}
+
+// ListenHandler queues a single node of a Kafka message to be saved on influxdb.
+// The returned channel yields the result once the batch holding it was written
+func (c *ConsumerController) ListenHandler(msg *models.Message) <-chan error {
+	data, err := c.getData(msg)
 	if err != nil {
 		logrus.Errorf("Error binding JSON: %s", err)
-		return fmt.Errorf("Error binding JSON: %s", err)
+		metrics.MessagesFailed.WithLabelValues(msg.Topic, metrics.ReasonDecode).Inc()
+		return database.Result(fmt.Errorf("Error binding JSON: %s", err))
 	}
+	metrics.MessagesDecoded.WithLabelValues(msg.Topic).Inc()
 
-	_, servErr := c.service.CreatePoint(data)
-	if !servErr.Ok() {
-		logrus.Errorf("Error saving point: %v", servErr)
-		return fmt.Errorf("Error saving point: %v", servErr)
+	if err := c.service.Validate(data); err != nil {
+		metrics.MessagesFailed.WithLabelValues(msg.Topic, metrics.ReasonInvalid).Inc()
+		return database.Result(err)
 	}
 
-	return nil
+	result := c.service.QueuePoint(data)
+	written := make(chan error, 1)
+	go func() {
+		err := <-result
+		if err != nil {
+			metrics.MessagesFailed.WithLabelValues(msg.Topic, metrics.ReasonWrite).Inc()
+		} else {
+			metrics.MessagesWritten.WithLabelValues(msg.Topic).Inc()
+		}
+		written <- err
+	}()
+	return written
+}
+
+// CloseStreams ends the live streams, so they don't hold the server shutdown back
+func (c *ConsumerController) CloseStreams() {
+	c.service.CloseStreams()
+}
+
+// Close flushes the pending points
+func (c *ConsumerController) Close() error {
+	return c.service.Close()
 }
 
 // CreateHandler saves a single node on influxdb
 func (c *ConsumerController) CreateHandler(ctx *gin.Context) {
 	var err error
-	var json map[string]string
+	var json map[string]interface{}
 	data := new(models.Data)
 	data.Tags = map[string]string{
 		"owner": ctx.Param("owner"),
@@ -61,7 +117,7 @@ func (c *ConsumerController) CreateHandler(ctx *gin.Context) {
 		"node":  ctx.Param("node"),
 	}
 
-	err = ctx.ShouldBindJSON(&json)
+	err = decodeJSON(ctx.Request.Body, &json)
 	if err != nil {
 		logrus.Errorf("Error binding JSON: %s", err)
 		ctx.String(http.StatusBadRequest, "Error binding request body JSON. Err:", err)
@@ -73,6 +129,10 @@ func (c *ConsumerController) CreateHandler(ctx *gin.Context) {
 		return
 	} else {
 		data.Fields = json
+		if err = c.mappingService.Coerce("", "", data.Fields); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
 		_, servErr := c.service.CreatePoint(data)
 		if !servErr.Ok() {
 			ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error saving point: %v", servErr))
@@ -83,9 +143,261 @@ func (c *ConsumerController) CreateHandler(ctx *gin.Context) {
 	ctx.String(http.StatusCreated, "State point created")
 }
 
+// BulkHandler saves many state points, each one with its own tags, sent as a JSON array or
+// as a stream of JSON documents (NDJSON). Nothing is written when the body is malformed,
+// otherwise every point is accepted or rejected on its own
+func (c *ConsumerController) BulkHandler(ctx *gin.Context) {
+	items, err := decodeBulk(ctx.Request.Body)
+	if err != nil {
+		logrus.Errorf("Error reading bulk body: %s", err)
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error reading request body: %s", err))
+		return
+	}
+
+	response := models.BulkResponse{Results: make([]models.BulkResult, len(items))}
+	points := []*models.Data{}
+	indexes := []int{}
+	for i, item := range items {
+		response.Results[i].Index = i
+		data, err := c.bulkData(item)
+		if err != nil {
+			response.Results[i].Error = err.Error()
+			continue
+		}
+		points = append(points, data)
+		indexes = append(indexes, i)
+	}
+
+	for i, err := range c.service.CreatePoints(points) {
+		if err != nil {
+			response.Results[indexes[i]].Error = err.Error()
+		} else {
+			response.Results[indexes[i]].Accepted = true
+		}
+	}
+
+	for _, result := range response.Results {
+		if result.Accepted {
+			response.Accepted++
+		} else {
+			response.Rejected++
+		}
+	}
+
+	if response.Rejected > 0 {
+		ctx.JSON(http.StatusMultiStatus, response)
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// decodeBulk reads the items of a JSON array or of a stream of JSON documents
+func decodeBulk(body io.Reader) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(body)
+	dec := json.NewDecoder(reader)
+	dec.UseNumber()
+
+	first, err := firstByte(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []json.RawMessage{}
+	if first == '[' {
+		if err = dec.Decode(&items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	for {
+		var item json.RawMessage
+		if err = dec.Decode(&item); err == io.EOF {
+			return items, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("item %d: %s", len(items), err)
+		}
+		items = append(items, item)
+	}
+}
+
+// decodeJSON decodes the numbers as json.Number, so the integers are persisted as InfluxDB
+// integers like the Avro int and long values, instead of floats
+func decodeJSON(body io.Reader, v interface{}) error {
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// firstByte peeks the first non-space byte of the body
+func firstByte(reader *bufio.Reader) (byte, error) {
+	for i := 1; ; i++ {
+		peek, err := reader.Peek(i)
+		if err == io.EOF {
+			return 0, fmt.Errorf("The request body is empty")
+		} else if err != nil {
+			return 0, err
+		}
+		if b := peek[i-1]; b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, nil
+		}
+	}
+}
+
+// bulkData reads a bulk item as a state point, coercing its fields as the Create endpoint does
+func (c *ConsumerController) bulkData(item json.RawMessage) (*models.Data, error) {
+	var point models.BulkPoint
+	if err := decodeJSON(bytes.NewReader(item), &point); err != nil {
+		return nil, fmt.Errorf("Error parsing point: %s", err)
+	}
+
+	data := &models.Data{Tags: point.Tags, DateTime: point.DateTime, Fields: point.Fields}
+	if err := c.mappingService.Coerce("", "", data.Fields); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteHandler is compatible with the InfluxDB /write endpoint, so gateways speaking line
+// protocol can send points through the same validation and writer as the other endpoints
+func (c *ConsumerController) WriteHandler(ctx *gin.Context) {
+	if db := ctx.Query("db"); db != "" && db != c.InfluxdbName {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("database not found: %q", db)})
+		return
+	}
+
+	body := io.Reader(ctx.Request.Body)
+	if ctx.GetHeader("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(ctx.Request.Body)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unable to decode gzip body: %s", err)})
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unable to read body: %s", err)})
+		return
+	}
+
+	points, err := services.ParseLineProtocol(content, ctx.Query("precision"))
+	if err != nil {
+		logrus.Errorf("Error parsing line protocol: %s", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rejected error
+	dropped := 0
+	for _, err := range c.service.CreatePoints(points) {
+		if err == nil {
+			continue
+		}
+		// failures writing to InfluxDB are reported as such, so the clients retry them
+		if database.IsRetryable(err) {
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if rejected == nil {
+			rejected = err
+		}
+		dropped++
+	}
+
+	if rejected != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("partial write: %s dropped=%d", rejected, dropped)})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
 // GetHandler retrive a single node from influxdb
 func (c *ConsumerController) GetHandler(ctx *gin.Context) {
-	var err error
+	data, err := c.queryData(ctx)
+	if err != nil {
+		logrus.Errorf("%s", err)
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error parsing time interval query params: %s", err))
+		return
+	}
+
+	if err = parsePage(ctx, &data.Options); err != nil {
+		logrus.Errorf("%s", err)
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error parsing pagination query params: %s", err))
+		return
+	}
+
+	format := strings.ToLower(ctx.Query("format"))
+	if format != "" && format != "json" && format != "geojson" {
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("The `format` parameter must be json or geojson. Got: %s", format))
+		return
+	}
+
+	points, next, servErr := c.service.GetPoints(data)
+	if !servErr.Ok() {
+		logrus.Errorf("%v", servErr)
+		ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error getting data: %v", servErr.Err))
+		return
+	}
+
+	if err = c.joinStatic(ctx, points); err != nil {
+		logrus.Errorf("%s", err)
+		ctx.String(http.StatusInternalServerError, fmt.Sprintf("Error getting static attributes: %s", err))
+		return
+	}
+
+	if !next.IsZero() {
+		ctx.Header("X-Next-Cursor", next.String())
+	}
+
+	if format == "geojson" {
+		collection, err := services.ToGeoJSON(points, strings.ToLower(ctx.Query("geometry")))
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx.Header("Content-Type", services.GeoJSONContentType)
+		ctx.JSON(http.StatusOK, collection)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, points)
+}
+
+// ExportHandler streams the points of a period as CSV, NDJSON or InfluxDB line protocol,
+// chosen by the `format` param or the Accept header
+func (c *ConsumerController) ExportHandler(ctx *gin.Context) {
+	data, err := c.queryData(ctx)
+	if err != nil {
+		logrus.Errorf("%s", err)
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error parsing time interval query params: %s", err))
+		return
+	}
+
+	format, err := services.ExportFormat(ctx.Query("format"), ctx.GetHeader("Accept"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx.Header("Content-Type", services.ExportFormats[format])
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="state.%s"`, format))
+	servErr := c.service.ExportPoints(data, format, ctx.Writer)
+	if !servErr.Ok() {
+		logrus.Errorf("Error exporting data: %v", servErr.Err)
+		// once the export started, the status was sent and the response is cut short instead
+		if !ctx.Writer.Written() {
+			ctx.Header("Content-Disposition", "")
+			ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error exporting data: %v", servErr.Err))
+		}
+	}
+}
+
+// DeleteHandler deletes the points of a period, or the whole history of the matching nodes
+// with `all=true` and no period. With `dryRun=true` it only counts the points that would be deleted
+func (c *ConsumerController) DeleteHandler(ctx *gin.Context) {
 	data := new(models.Data)
 	data.Tags = map[string]string{
 		"owner": ctx.Param("owner"),
@@ -93,27 +405,181 @@ func (c *ConsumerController) GetHandler(ctx *gin.Context) {
 		"node":  ctx.Param("node"),
 	}
 
-	data.StartDateTime, data.EndDateTime, err = utils.ParsePeriodDateTime(ctx.Query("time"), ctx.Query("startDateTime"), ctx.Query("endDateTime"))
+	var err error
+	if ctx.Query("time") != "" || ctx.Query("startDateTime") != "" || ctx.Query("endDateTime") != "" {
+		data.StartDateTime, data.EndDateTime, err = c.periods.Parse(ctx.Query("time"), ctx.Query("startDateTime"), ctx.Query("endDateTime"))
+		if err != nil {
+			logrus.Errorf("%s", err)
+			ctx.String(http.StatusBadRequest, fmt.Sprintf("Error parsing time interval query params: %s", err))
+			return
+		}
+	}
+
+	dryRun := false
+	if param := ctx.Query("dryRun"); param != "" {
+		if dryRun, err = strconv.ParseBool(param); err != nil {
+			ctx.String(http.StatusBadRequest, fmt.Sprintf("The `dryRun` parameter must be true or false. Got: %s", param))
+			return
+		}
+	}
+
+	all := false
+	if param := ctx.Query("all"); param != "" {
+		if all, err = strconv.ParseBool(param); err != nil {
+			ctx.String(http.StatusBadRequest, fmt.Sprintf("The `all` parameter must be true or false. Got: %s", param))
+			return
+		}
+	}
+
+	result, servErr := c.service.DeletePoints(data, dryRun, all)
+	if !servErr.Ok() {
+		logrus.Errorf("%v", servErr)
+		ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error deleting data: %v", servErr.Err))
+		return
+	}
+
+	if !dryRun {
+		logrus.Infof("Deleted %d points of %s between %s and %s", result.Points, data.Tags, data.StartDateTime, data.EndDateTime)
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+// StreamHandler pushes the points of the matching nodes as Server-Sent Events while they are
+// written. A `dropped` event tells how many points didn't fit the buffer of a slow client
+func (c *ConsumerController) StreamHandler(ctx *gin.Context) {
+	sub, err := c.service.Subscribe(map[string]string{
+		"owner": ctx.Param("owner"),
+		"thing": ctx.Param("thing"),
+		"node":  ctx.Param("node"),
+	})
 	if err != nil {
-		logrus.Errorf("%s", err)
-		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error parsing time interval query params: %s", err))
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error subscribing: %s", err))
 		return
 	}
+	defer c.service.Unsubscribe(sub)
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case point, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			if dropped := sub.Dropped(); dropped > 0 {
+				ctx.SSEvent("dropped", dropped)
+			}
+			ctx.SSEvent("point", point)
+			return true
+		case <-heartbeat.C:
+			// keeps proxies from closing an idle connection
+			ctx.SSEvent("ping", time.Now().UTC().Format(time.RFC3339))
+			return true
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
 
-	points, servErr := c.service.GetPoints(data)
+// queryData reads the tags, period and query options shared by the query endpoints
+func (c *ConsumerController) queryData(ctx *gin.Context) (data *models.Data, err error) {
+	data = new(models.Data)
+	data.Tags = map[string]string{
+		"owner": ctx.Param("owner"),
+		"thing": ctx.Param("thing"),
+		"node":  ctx.Param("node"),
+	}
+
+	data.StartDateTime, data.EndDateTime, err = c.periods.Parse(ctx.Query("time"), ctx.Query("startDateTime"), ctx.Query("endDateTime"))
+	if err != nil {
+		return nil, err
+	}
+
+	data.Options = models.QueryOptions{
+		Aggregate: strings.ToLower(ctx.Query("aggregate")),
+		Fields:    splitList(ctx.Query("fields")),
+		GroupBy:   ctx.Query("groupBy"),
+		Fill:      ctx.Query("fill"),
+	}
+	return data, nil
+}
+
+// LatestHandler retrieves the last known state of the matching nodes. The `+` wildcard
+// returns the last state of, for instance, every thing of an owner
+func (c *ConsumerController) LatestHandler(ctx *gin.Context) {
+	data := new(models.Data)
+	data.Tags = map[string]string{
+		"owner": ctx.Param("owner"),
+		"thing": ctx.Param("thing"),
+		"node":  ctx.Param("node"),
+	}
+	data.Options.Fields = splitList(ctx.Query("fields"))
+
+	points, servErr := c.service.GetLatestPoints(data)
 	if !servErr.Ok() {
 		logrus.Errorf("%v", servErr)
-		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error getting data: %v", servErr))
+		ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error getting latest data: %v", servErr.Err))
 		return
 	}
 
-	println(points)
+	if err := c.joinStatic(ctx, points); err != nil {
+		logrus.Errorf("%s", err)
+		ctx.String(http.StatusInternalServerError, fmt.Sprintf("Error getting static attributes: %s", err))
+		return
+	}
 
 	ctx.JSON(http.StatusOK, points)
 }
 
-func getDateTime(node map[string]string) (error, time.Time) {
-	dateTimeString, ok := (node)["dateTime"]
+// joinStatic joins the static attributes into the points when the `static` param is true
+func (c *ConsumerController) joinStatic(ctx *gin.Context, points []models.StatePoint) error {
+	join, _ := strconv.ParseBool(ctx.Query("static"))
+	if !join || c.staticService == nil {
+		return nil
+	}
+	return c.staticService.Join(points)
+}
+
+// parsePage reads the `limit`, `cursor` and `order` query params
+func parsePage(ctx *gin.Context, options *models.QueryOptions) (err error) {
+	if limit := ctx.Query("limit"); limit != "" {
+		if options.Limit, err = strconv.Atoi(limit); err != nil || options.Limit <= 0 {
+			return fmt.Errorf("The `limit` parameter must be a positive integer. Got: %s", limit)
+		}
+	}
+
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		if options.Cursor, err = models.ParseCursor(cursor); err != nil {
+			return fmt.Errorf("The `cursor` parameter must be the `X-Next-Cursor` header of the previous page. Got: %s", cursor)
+		}
+	}
+
+	switch order := strings.ToLower(ctx.Query("order")); order {
+	case "", "asc":
+	case "desc":
+		options.Descending = true
+	default:
+		return fmt.Errorf("The `order` parameter must be asc or desc. Got: %s", order)
+	}
+	return nil
+}
+
+// splitList splits a comma separated query param
+func splitList(param string) []string {
+	var values []string
+	for _, value := range strings.Split(param, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getDateTime(node map[string]interface{}) (error, time.Time) {
+	dateTimeString, ok := (node)["dateTime"].(string)
 	if !ok {
 		logrus.Errorf("dateTime attribute not provied.")
 		return fmt.Errorf("The attribute `dateTime` must be provided in the request body. This is the date and time that the data was collected."), time.Time{}
@@ -129,8 +595,18 @@ func getDateTime(node map[string]string) (error, time.Time) {
 	return nil, dateTime
 }
 
+// isWireFormat tells whether a payload starts with the magic byte and schema ID of the schema registry
+func isWireFormat(payload []byte) bool {
+	return len(payload) >= 5 && payload[0] == 0
+}
+
 // https://docs.confluent.io/current/schema-registry/serdes-develop/index.html#wire-format
 func (c *ConsumerController) getSchemaOfMessage(payload []byte) (string, string, error) {
+	// tombstones and payloads not serialized with the schema registry have no schema ID
+	if !isWireFormat(payload) {
+		return "", "", fmt.Errorf("The payload doesn't start with the magic byte and schema ID of the schema registry. Payload size: %d bytes", len(payload))
+	}
+
 	var schemaID int32
 	logrus.Tracef("Payload Schema ID: %s", hex.EncodeToString(payload[1:5]))
 	buf := bytes.NewBuffer(payload[1:5])
@@ -172,60 +648,49 @@ func (c *ConsumerController) decodeAvro(payload []byte, result interface{}) (str
 	return schemaName, nil
 }
 
-func (c *ConsumerController) getData(keyPayload []byte, messagePayload []byte) (data *models.Data, err error) {
-	var dateTime time.Time
-
-	//Parse Key Payload
+func (c *ConsumerController) getData(msg *models.Message) (data *models.Data, err error) {
+	//Parse Key Payload. Keys not serialized with the schema registry are taken as plain strings
 	var messageKey string
-	_, err = c.decodeAvro(keyPayload, &messageKey)
-	if err != nil {
-		logrus.Errorf("Error decoding key payload: %s", err)
-		return
+	if isWireFormat(msg.Key) {
+		_, err = c.decodeAvro(msg.Key, &messageKey)
+		if err != nil {
+			logrus.Errorf("Error decoding key payload: %s", err)
+			return
+		}
+	} else {
+		messageKey = string(msg.Key)
 	}
 	logrus.Debugf("Key parsed: %s", messageKey)
 
 	//Parse Message Payload
 	var message map[string]interface{}
-	messageSchemaName, err := c.decodeAvro(messagePayload, &message)
+	messageSchemaName, err := c.decodeAvro(msg.Value, &message)
 	if err != nil {
 		logrus.Errorf("Error decoding message payload: %s", err)
 		return
 	}
 	logrus.Debugf("Record parsed: %s", message)
 
-	dateTime, err = time.Parse(time.RFC3339, message["dateTime"].(string))
+	data, err = c.mappingService.Map(msg.Topic, messageSchemaName, message)
 	if err != nil {
-		dateTime, err = time.Parse("2006-01-02T15:04:05Z0700", message["dateTime"].(string))
-		if err != nil {
-			logrus.Errorf("Error on parse dateTime: %s", err)
-			return
-		}
+		logrus.Errorf("Error mapping record: %s", err)
+		return
 	}
 
-	data = new(models.Data)
-	data.DateTime = dateTime
-
-	rg := regexp.MustCompile(`owner/(?P<Owner>\w+)/thing/(?P<Thing>\w+)/node/(?P<Node>\w+)`)
-	if !rg.MatchString(messageKey) {
-		err = fmt.Errorf("The keys doesn't matches with pattern (owner/:owner/thing/:thing/node/:node): %s", messageKey)
+	// tags taken from the key have precedence over the ones from headers and record
+	for k, v := range c.mappingService.HeaderTags(msg.Headers) {
+		data.Tags[k] = v
+	}
+	keyTags, keyErr := c.mappingService.KeyTags(messageKey)
+	if keyErr != nil && (data.Tags["owner"] == "" || data.Tags["thing"] == "" || data.Tags["node"] == "") {
+		err = keyErr
 		logrus.Errorf("Error on parsing tags: %s", err)
 		return
 	}
-	keys := rg.FindStringSubmatch(messageKey)
-
-	data.Tags = map[string]string{
-		"owner":    keys[1],
-		"thing":    keys[2],
-		"node":     keys[3],
-		"schema_0": messageSchemaName,
-	}
-
-	data.Fields = map[string]string{
-		"lat":  message["lat"].(string),
-		"lon":  message["lon"].(string),
-		"mci":  message["mci"].(string),
-		"type": message["type"].(string),
+	for k, v := range keyTags {
+		data.Tags[k] = v
 	}
+	data.Tags["schema_0"] = messageSchemaName
 
 	return
 }
@@ -5,8 +5,13 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"strings"
 	"testing"
 
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+	"github.com/labbsr0x/kafka2influxdb/web/services"
+
 	"github.com/docker/docker/pkg/testutil/assert"
 	"github.com/hamba/avro"
 )
@@ -61,3 +66,42 @@ func TestGetByteArrayMagicByteAndSchemaID(t *testing.T) {
 	fmt.Printf("SchemaID: %d\n", schemaID)
 	fmt.Printf("Message: %s\n", msg)
 }
+
+func TestDecodeBulk(t *testing.T) {
+	items, err := decodeBulk(strings.NewReader(` [{"tags":{"owner":"movbb"}}, {"fields":{"lat":1}}]`))
+	assert.NilError(t, err)
+	assert.Equal(t, len(items), 2)
+
+	items, err = decodeBulk(strings.NewReader("{\"tags\":{\"owner\":\"movbb\"}}\n{\"fields\":{\"lat\":1}}\n\n{}\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(items), 3)
+	assert.Equal(t, string(items[2]), "{}")
+
+	_, err = decodeBulk(strings.NewReader("{}\n{\"tags\":"))
+	assert.Error(t, err, "item 1")
+
+	_, err = decodeBulk(strings.NewReader("  \n"))
+	assert.Error(t, err, "empty")
+}
+
+func TestBulkDataKeepsIntegerFields(t *testing.T) {
+	mappings := config.DefaultMappingConfig()
+	mappings.Default.Coerce = map[string]string{"lat": "float"}
+	c := &ConsumerController{mappingService: services.NewMappingService(&config.WebBuilder{Mappings: mappings})}
+
+	data, err := c.bulkData([]byte(`{"dateTime":"2020-05-24T14:27:33Z","fields":{"lat":-22,"speed":40,"temperature":21.5}}`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, data.Fields, map[string]interface{}{
+		"lat":         -22.0,
+		"speed":       int64(40),
+		"temperature": 21.5,
+	})
+}
+
+func TestGetDataRejectsPayloadsWithoutSchemaID(t *testing.T) {
+	c := new(ConsumerController)
+	for _, value := range [][]byte{nil, {0}, {0, 0, 0, 1}, []byte(`{"a":1}`)} {
+		_, err := c.getData(&models.Message{Topic: "owner", Value: value})
+		assert.Error(t, err, "magic byte and schema ID")
+	}
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/labbsr0x/kafka2influxdb/web/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthController struct {
+	service *services.HealthService
+}
+
+func NewHealthController(service *services.HealthService) *HealthController {
+	instance := new(HealthController)
+	instance.service = service
+	return instance
+}
+
+// LiveHandler tells the process is alive
+func (c *HealthController) LiveHandler(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// ReadyHandler checks every dependency, answering 503 when any of them is down
+func (c *HealthController) ReadyHandler(ctx *gin.Context) {
+	ready, checks := c.service.Ready()
+	if !ready {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "checks": checks})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labbsr0x/kafka2influxdb/web/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type StaticController struct {
+	service *services.StaticService
+}
+
+func NewStaticController(service *services.StaticService) *StaticController {
+	instance := new(StaticController)
+	instance.service = service
+	return instance
+}
+
+// GetHandler retrieves the static attributes of a node
+func (c *StaticController) GetHandler(ctx *gin.Context) {
+	attributes, servErr := c.service.GetAttributes(staticTags(ctx))
+	if !servErr.Ok() {
+		ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error getting static attributes: %v", servErr.Err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, attributes)
+}
+
+// PutHandler replaces the static attributes of a node
+func (c *StaticController) PutHandler(ctx *gin.Context) {
+	var attributes map[string]interface{}
+	if err := ctx.ShouldBindJSON(&attributes); err != nil {
+		logrus.Errorf("Error binding JSON: %s", err)
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error binding request body JSON. Err: %s", err))
+		return
+	}
+
+	saved, servErr := c.service.ReplaceAttributes(staticTags(ctx), attributes)
+	if !servErr.Ok() {
+		ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error saving static attributes: %v", servErr.Err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, saved)
+}
+
+// PatchHandler changes the given static attributes of a node. The null ones are removed
+func (c *StaticController) PatchHandler(ctx *gin.Context) {
+	var attributes map[string]interface{}
+	if err := ctx.ShouldBindJSON(&attributes); err != nil {
+		logrus.Errorf("Error binding JSON: %s", err)
+		ctx.String(http.StatusBadRequest, fmt.Sprintf("Error binding request body JSON. Err: %s", err))
+		return
+	}
+
+	saved, servErr := c.service.MergeAttributes(staticTags(ctx), attributes)
+	if !servErr.Ok() {
+		ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error saving static attributes: %v", servErr.Err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, saved)
+}
+
+// DeleteHandler removes every static attribute of a node
+func (c *StaticController) DeleteHandler(ctx *gin.Context) {
+	if servErr := c.service.DeleteAttributes(staticTags(ctx)); !servErr.Ok() {
+		ctx.String(servErr.SetStatusCode(), fmt.Sprintf("Error deleting static attributes: %v", servErr.Err))
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Close closes the static database
+func (c *StaticController) Close() error {
+	return c.service.Close()
+}
+
+func staticTags(ctx *gin.Context) map[string]string {
+	return map[string]string{
+		"owner": ctx.Param("owner"),
+		"thing": ctx.Param("thing"),
+		"node":  ctx.Param("node"),
+	}
+}
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "kafka2influxdb"
+
+// Reasons a consumed message fails
+const (
+	ReasonDecode  = "decode"
+	ReasonInvalid = "invalid"
+	ReasonWrite   = "write"
+)
+
+var (
+	// MessagesConsumed counts the messages read from Kafka by topic
+	MessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_consumed_total",
+		Help:      "Messages read from Kafka.",
+	}, []string{"topic"})
+
+	// MessagesDecoded counts the messages decoded and mapped to a point by topic
+	MessagesDecoded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_decoded_total",
+		Help:      "Messages decoded and mapped to a state point.",
+	}, []string{"topic"})
+
+	// MessagesWritten counts the messages whose point was written to InfluxDB by topic
+	MessagesWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_written_total",
+		Help:      "Messages whose state point was written to InfluxDB.",
+	}, []string{"topic"})
+
+	// MessagesFailed counts the messages that couldn't be ingested by topic and reason
+	MessagesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages that could not be ingested, by reason: decode, invalid or write.",
+	}, []string{"topic", "reason"})
+
+	// ConsumerLag is the number of messages of a partition not processed yet
+	ConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "consumer_lag",
+		Help:      "Messages of the partition not processed yet.",
+	}, []string{"topic", "partition"})
+
+	// PartitionErrors counts the errors of the partition consumers by the action they required:
+	// recover, reopen or reset
+	PartitionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "partition_errors_total",
+		Help:      "Errors of the partition consumers, by the action they required: recover, reopen or reset.",
+	}, []string{"topic", "partition", "action"})
+
+	// WriteDuration observes how long InfluxDB takes to write a batch
+	WriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "influxdb_write_duration_seconds",
+		Help:      "Latency of the InfluxDB batch writes.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	schemaCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "schema_cache_requests_total",
+		Help:      "Schema lookups, by result: hit or miss.",
+	}, []string{"result"})
+
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	schemaCacheHits    uint64
+	schemaCacheLookups uint64
+)
+
+func init() {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "schema_cache_hit_ratio",
+		Help:      "Share of the schema lookups answered by the cache since the start.",
+	}, SchemaCacheHitRatio)
+}
+
+// SchemaCacheLookup records whether a schema was found in the cache
+func SchemaCacheLookup(hit bool) {
+	atomic.AddUint64(&schemaCacheLookups, 1)
+	if hit {
+		atomic.AddUint64(&schemaCacheHits, 1)
+		schemaCacheRequests.WithLabelValues("hit").Inc()
+		return
+	}
+	schemaCacheRequests.WithLabelValues("miss").Inc()
+}
+
+// SchemaCacheHitRatio is the share of the schema lookups answered by the cache, 0 before any lookup
+func SchemaCacheHitRatio() float64 {
+	lookups := atomic.LoadUint64(&schemaCacheLookups)
+	if lookups == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&schemaCacheHits)) / float64(lookups)
+}
+
+// SetConsumerLag sets the lag of a partition from its high water mark and the last offset processed
+func SetConsumerLag(topic string, partition int32, highWaterMark int64, offset int64) {
+	lag := highWaterMark - offset - 1
+	if lag < 0 {
+		lag = 0
+	}
+	ConsumerLag.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+// Middleware records the requests by route, so the path params don't blow the label cardinality up
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(ctx.Writer.Status())
+		httpRequests.WithLabelValues(ctx.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(ctx.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestSetConsumerLag(t *testing.T) {
+	SetConsumerLag("state", 2, 100, 89)
+	assert.Equal(t, testutil.ToFloat64(ConsumerLag.WithLabelValues("state", "2")), float64(10))
+
+	// the high water mark is fetched apart from the messages, so it may fall behind
+	SetConsumerLag("state", 2, 100, 120)
+	assert.Equal(t, testutil.ToFloat64(ConsumerLag.WithLabelValues("state", "2")), float64(0))
+}
+
+func TestSchemaCacheHitRatio(t *testing.T) {
+	SchemaCacheLookup(false)
+	SchemaCacheLookup(true)
+	SchemaCacheLookup(true)
+	SchemaCacheLookup(true)
+	assert.Equal(t, SchemaCacheHitRatio(), 0.75)
+}
+
+func TestMiddlewareLabelsByRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app := gin.New()
+	app.Use(Middleware())
+	app.GET("/owner/:owner/thing/:thing/node/:node", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	for _, path := range []string{"/owner/a/thing/b/node/c", "/owner/d/thing/e/node/f", "/missing"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/owner/:owner/thing/:thing/node/:node", "200")), float64(2))
+	assert.Equal(t, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "404")), float64(1))
+}
@@ -2,36 +2,106 @@ package repositories
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/labbsr0x/kafka2influxdb/database"
 	"github.com/labbsr0x/kafka2influxdb/database/models"
 	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/sirupsen/logrus"
 )
 
 type ConsumerRepository struct {
-	db database.Database
+	db     database.Database
+	writer *database.BatchWriter
 }
 
+// NewConsumerRepository connects to influxdb once and keeps the connection for the lifetime of the repository
 func NewConsumerRepository(webBuilder *config.WebBuilder) *ConsumerRepository {
 	instance := new(ConsumerRepository)
-	instance.db = new(database.DefaultDatabase).Init(webBuilder)
+	instance.db = new(database.DefaultDatabase).Init(webBuilder).Connect()
+	instance.writer = database.NewBatchWriter(webBuilder, instance.db, database.NewRetryPolicy(webBuilder))
 	return instance
 }
 
 func (r *ConsumerRepository) GetPoints(element *models.Data) (points []models.StatePoint, err error) {
-	if points, err = r.db.Connect().GetPoints(element); err != nil {
+	if points, err = r.db.GetPoints(element); err != nil {
 		fmt.Printf("GetPointErr:%s", err)
 	}
 
-	r.db.Close()
 	return
 }
 
+// GetLatestPoints gets the last point of every owner/thing/node matching the tags
+func (r *ConsumerRepository) GetLatestPoints(element *models.Data) (points []models.StatePoint, err error) {
+	if points, err = r.db.GetLatestPoints(element); err != nil {
+		logrus.Errorf("Error getting the latest points: %s", err)
+	}
+
+	return
+}
+
+// ExportPoints streams the points matching the query to the handler
+func (r *ConsumerRepository) ExportPoints(element *models.Data, handler func(point models.StatePoint) error) (err error) {
+	if err = r.db.ExportPoints(element, handler); err != nil {
+		logrus.Errorf("Error exporting points: %s", err)
+	}
+
+	return
+}
+
+// FieldKeys gets the persisted fields and their types
+func (r *ConsumerRepository) FieldKeys() (map[string]string, error) {
+	return r.db.FieldKeys()
+}
+
+// CountPoints counts the points of every owner/thing/node matching the query
+func (r *ConsumerRepository) CountPoints(element *models.Data) (counts []models.SeriesCount, err error) {
+	if counts, err = r.db.CountPoints(element); err != nil {
+		logrus.Errorf("Error counting points: %s", err)
+	}
+
+	return
+}
+
+// DeletePoints deletes the points matching the query
+func (r *ConsumerRepository) DeletePoints(element *models.Data) (err error) {
+	if err = r.db.DeletePoints(element); err != nil {
+		logrus.Errorf("Error deleting points of %v: %s", element.Tags, err)
+	}
+
+	return
+}
+
+// CreatePoint writes a point and waits for the batch holding it to be flushed
 func (r *ConsumerRepository) CreatePoint(element *models.Data) (err error) {
-	if _, err = r.db.Connect().CreatePoint(element); err != nil {
+	if err = <-r.QueuePoint(element); err != nil {
 		fmt.Printf("CreatePointErr:%s", err)
 	}
 
-	r.db.Close()
 	return
 }
+
+// QueuePoint queues a point in the batch writer. Transient InfluxDB failures are retried
+// before the result is yielded, while the queue fills up and pauses its producers
+func (r *ConsumerRepository) QueuePoint(element *models.Data) <-chan error {
+	point, err := r.db.NewPoint(element)
+	if err != nil {
+		return database.Result(err)
+	}
+
+	return r.writer.Write(point)
+}
+
+// Ping checks that the database answers within the timeout
+func (r *ConsumerRepository) Ping(timeout time.Duration) error {
+	return r.db.Ping(timeout)
+}
+
+// Close flushes the pending points and closes the influxdb connection
+func (r *ConsumerRepository) Close() error {
+	if err := r.writer.Close(); err != nil {
+		return err
+	}
+	return r.db.Close()
+}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"github.com/labbsr0x/kafka2influxdb/database"
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+type StaticRepository struct {
+	db database.StaticDatabase
+}
+
+// NewStaticRepository opens the static database once and keeps it for the lifetime of the repository
+func NewStaticRepository(webBuilder *config.WebBuilder) *StaticRepository {
+	instance := new(StaticRepository)
+	instance.db = new(database.DefaultStaticDatabase).Init(webBuilder).Connect()
+	return instance
+}
+
+func (r *StaticRepository) GetAttributes(owner string, thing string, node string) (attributes map[string]interface{}, err error) {
+	if attributes, err = r.db.GetAttributes(owner, thing, node); err != nil {
+		logrus.Errorf("Error getting static attributes: %s", err)
+	}
+
+	return
+}
+
+func (r *StaticRepository) UpdateAttributes(owner string, thing string, node string, update func(attributes map[string]interface{}) error) (attributes map[string]interface{}, err error) {
+	if attributes, err = r.db.UpdateAttributes(owner, thing, node, update); err != nil {
+		logrus.Errorf("Error updating static attributes: %s", err)
+	}
+
+	return
+}
+
+func (r *StaticRepository) DeleteAttributes(owner string, thing string, node string) (err error) {
+	if err = r.db.DeleteAttributes(owner, thing, node); err != nil {
+		logrus.Errorf("Error deleting static attributes: %s", err)
+	}
+
+	return
+}
+
+// Close closes the static database
+func (r *StaticRepository) Close() error {
+	return r.db.Close()
+}
@@ -2,8 +2,12 @@ package services
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/labbsr0x/kafka2influxdb/database"
+	"github.com/labbsr0x/kafka2influxdb/database/influxql"
 	"github.com/labbsr0x/kafka2influxdb/database/models"
 	"github.com/labbsr0x/kafka2influxdb/web/config"
 	"github.com/labbsr0x/kafka2influxdb/web/repositories"
@@ -11,29 +15,104 @@ import (
 )
 
 type ConsumerService struct {
-	repo *repositories.ConsumerRepository
+	repo    *repositories.ConsumerRepository
+	maxRows int
+	tail    *TailHub
 }
 
 func NewConsumerService(webBuilder *config.WebBuilder) *ConsumerService {
 	instance := new(ConsumerService)
 	instance.repo = repositories.NewConsumerRepository(webBuilder)
+	instance.maxRows = webBuilder.MaxRows
+	instance.tail = NewTailHub(webBuilder.StreamBuffer)
 	return instance
 }
 
-//GetPoint gets an Consumer by primary key
-func (s *ConsumerService) GetPoints(data *models.Data) (points []models.StatePoint, servErr utils.ServiceError) {
+//GetLatestPoints gets the last known state of every owner/thing/node matching the tags
+func (s *ConsumerService) GetLatestPoints(data *models.Data) (points []models.StatePoint, servErr utils.ServiceError) {
+	if err := validateQueryTags(data.Tags); err != nil {
+		servErr.Invalid = true
+		servErr.Err = err
+		return
+	}
+
+	var err error
+	if points, err = s.repo.GetLatestPoints(data); err != nil {
+		servErr.Internal = true
+		servErr.Err = err
+	}
+	return
+}
+
+//DeletePoints deletes the points of the nodes matching the tags in the data period. Deleting the
+//whole history, when there is no period, must be confirmed by `all`. The dry run only counts the
+//points that would be deleted
+func (s *ConsumerService) DeletePoints(data *models.Data, dryRun bool, all bool) (result models.DeleteResult, servErr utils.ServiceError) {
+	if err := validateQueryTags(data.Tags); err != nil {
+		servErr.Invalid = true
+		servErr.Err = err
+		return
+	}
+
+	if !dryRun && !all && data.StartDateTime.IsZero() && data.EndDateTime.IsZero() {
+		servErr.Invalid = true
+		servErr.Err = fmt.Errorf("A period must be provided to delete points. Use `all=true` to delete the whole history of the matching nodes")
+		return
+	}
+
+	counts, err := s.repo.CountPoints(data)
+	if err == nil && !dryRun {
+		err = s.repo.DeletePoints(data)
+	}
+	if err != nil {
+		servErr.Internal = true
+		servErr.Err = err
+		return
+	}
+
+	result = models.DeleteResult{DryRun: dryRun, Series: counts}
+	for _, count := range counts {
+		result.Points += count.Points
+	}
+	return
+}
+
+//GetPoint gets a page of points. When there are more points than the requested limit,
+//the cursor of the next page is returned
+func (s *ConsumerService) GetPoints(data *models.Data) (points []models.StatePoint, next models.Cursor, servErr utils.ServiceError) {
 	var err error
 	err = s.ValidateQueryParams(data)
 
 	if err == nil {
-		if points, err = s.repo.GetPoints(data); err != nil {
-			fmt.Printf("GetPointErr:%s", err)
-			if len(points) == 0 {
-				servErr.Not_Found = true
-			} else {
-				servErr.Internal = true
+		// one point more than the page size tells whether there is a next page
+		requested := data.Options.Limit
+		limit := requested
+		if limit == 0 {
+			limit = s.maxRows
+		}
+		data.Options.Limit = limit + 1
+		points, err = s.repo.GetPoints(data)
+		data.Options.Limit = requested
+
+		if err == nil && len(points) > limit {
+			if requested == 0 {
+				servErr.Invalid = true
+				servErr.Err = fmt.Errorf("The query returns more than %d points. Use the `limit` and `cursor` params to page through the results or narrow the time range", s.maxRows)
+				return nil, next, servErr
 			}
+			points = points[:limit]
+			next = models.NextCursor(data.Options.Cursor, points)
 		}
+
+		// an empty page is a valid result, so only the failures of the query are errors
+		if err != nil {
+			fmt.Printf("GetPointErr:%s", err)
+			servErr.Internal = true
+			servErr.Err = err
+		}
+	} else {
+		servErr.Invalid = true
+		servErr.Err = err
 	}
 	return
 }
@@ -47,14 +126,95 @@ func (s *ConsumerService) CreatePoint(data *models.Data) (body *models.Data, ser
 		if err = s.repo.CreatePoint(data); err != nil {
 			fmt.Printf("CreatePointErr:%s", err)
 			servErr.Internal = true
+			servErr.Err = err
 		} else {
 			body = data
+			s.publish(data)
 		}
+	} else {
+		servErr.Invalid = true
+		servErr.Err = err
 	}
 
 	return
 }
 
+// QueuePoint validates and queues a new data to be written with the next batch. The returned
+// channel yields the write result
+func (s *ConsumerService) QueuePoint(data *models.Data) <-chan error {
+	if err := s.Validate(data); err != nil {
+		return database.Result(err)
+	}
+
+	result := s.repo.QueuePoint(data)
+	if !s.tail.HasSubscribers() {
+		return result
+	}
+
+	// the point is streamed once written, without holding the result back
+	written := make(chan error, 1)
+	go func() {
+		err := <-result
+		if err == nil {
+			s.publish(data)
+		}
+		written <- err
+	}()
+	return written
+}
+
+// PingDatabase checks that the database answers within the timeout
+func (s *ConsumerService) PingDatabase(timeout time.Duration) error {
+	return s.repo.Ping(timeout)
+}
+
+// Subscribe streams the points matching the tags as they are written
+func (s *ConsumerService) Subscribe(tags map[string]string) (*Subscription, error) {
+	return s.tail.Subscribe(tags)
+}
+
+// Unsubscribe stops streaming points to the subscription
+func (s *ConsumerService) Unsubscribe(sub *Subscription) {
+	s.tail.Unsubscribe(sub)
+}
+
+func (s *ConsumerService) publish(data *models.Data) {
+	s.tail.Publish(models.StatePoint{
+		Owner:      data.Tags["owner"],
+		Thing:      data.Tags["thing"],
+		Node:       data.Tags["node"],
+		Tags:       data.Tags,
+		Attributes: data.Fields,
+		DateTime:   data.DateTime,
+	})
+}
+
+// CreatePoints queues every point in the batch writer before waiting for them, so they are
+// written in batches. The errors follow the order of the points, nil for the accepted ones
+func (s *ConsumerService) CreatePoints(points []*models.Data) []error {
+	results := make([]<-chan error, len(points))
+	for i, data := range points {
+		results[i] = s.QueuePoint(data)
+	}
+
+	errs := make([]error, len(points))
+	for i, result := range results {
+		errs[i] = <-result
+	}
+	return errs
+}
+
+// CloseStreams ends every live stream
+func (s *ConsumerService) CloseStreams() {
+	s.tail.Close()
+}
+
+// Close flushes the pending points and releases the database connection
+func (s *ConsumerService) Close() error {
+	s.tail.Close()
+	return s.repo.Close()
+}
+
 func (s *ConsumerService) Validate(data *models.Data) error {
 	if (data.DateTime == time.Time{}) {
 		return fmt.Errorf("The `datetime` parameter is required for sensor data. If you are trying to provide a static data, please prefix the attribute name using the `$` simbol, like: $name, $unity and so on")
@@ -76,17 +236,70 @@ func (s *ConsumerService) Validate(data *models.Data) error {
 }
 
 func (s *ConsumerService) ValidateQueryParams(data *models.Data) error {
-	if (data.StartDateTime == time.Time{} || data.EndDateTime == time.Time{}) {
-		return fmt.Errorf("The `startDateTime` and `endDateTime` parameter are required for querying the database")
+	if data.StartDateTime.IsZero() && data.EndDateTime.IsZero() {
+		return fmt.Errorf("The `startDateTime` or `endDateTime` parameter is required for querying the database")
 	}
 
-	if data.Tags == nil || len(data.Tags) == 0 {
+	if err := validateQueryTags(data.Tags); err != nil {
+		return err
+	}
+
+	return s.ValidateQueryOptions(data.Options)
+}
+
+// validateQueryTags requires at least one of the owner, thing or node tags not to be a wildcard
+func validateQueryTags(tags map[string]string) error {
+	if tags == nil || len(tags) == 0 {
 		return fmt.Errorf("The `tags` parameter is required for any data being inserted into AgroWS main database as it is the source of truth and hence must have relevant data")
 	}
 
-	if (data.Tags["owner"] == "" && data.Tags["thing"] == "" && data.Tags["node"] == "") || (data.Tags["owner"] == "+" && data.Tags["thing"] == "+" && data.Tags["node"] == "+") {
+	if (tags["owner"] == "" && tags["thing"] == "" && tags["node"] == "") || (tags["owner"] == "+" && tags["thing"] == "+" && tags["node"] == "+") {
 		return fmt.Errorf("At leats on of the 'owner', 'thing' or 'node' `tags` must be provided for querying the database")
 	}
+	return nil
+}
+
+func (s *ConsumerService) ValidateQueryOptions(options models.QueryOptions) error {
+	if options.Aggregate != "" && !influxql.Aggregations[options.Aggregate] {
+		return fmt.Errorf("The `aggregate` parameter must be one of %s. Got: %s", aggregationNames(), options.Aggregate)
+	}
+
+	if options.GroupBy != "" {
+		if options.Aggregate == "" {
+			return fmt.Errorf("The `groupBy` parameter requires an `aggregate` function")
+		}
+		if !influxql.ValidDuration(options.GroupBy) {
+			return fmt.Errorf("The `groupBy` parameter must be a duration like 30s, 5m, 1h or 1d. Got: %s", options.GroupBy)
+		}
+	}
+
+	if options.Fill != "" {
+		if options.GroupBy == "" {
+			return fmt.Errorf("The `fill` parameter requires a `groupBy` interval")
+		}
+		if !influxql.ValidFill(options.Fill) {
+			return fmt.Errorf("The `fill` parameter must be one of null, none, previous, linear or a number. Got: %s", options.Fill)
+		}
+	}
+
+	if options.Limit < 0 || options.Limit > s.maxRows {
+		return fmt.Errorf("The `limit` parameter must be between 1 and %d. Got: %d", s.maxRows, options.Limit)
+	}
+
+	for _, field := range options.Fields {
+		if field == "" || field == "time" {
+			return fmt.Errorf("Invalid field `%s` in the `fields` parameter", field)
+		}
+	}
 
 	return nil
 }
+
+func aggregationNames() string {
+	names := make([]string, 0, len(influxql.Aggregations))
+	for name := range influxql.Aggregations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
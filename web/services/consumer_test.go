@@ -0,0 +1,57 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestValidateQueryOptions(t *testing.T) {
+	service := new(ConsumerService)
+
+	assert.NilError(t, service.ValidateQueryOptions(models.QueryOptions{}))
+	assert.NilError(t, service.ValidateQueryOptions(models.QueryOptions{Aggregate: "mean", Fields: []string{"lat", "lon"}, GroupBy: "1h", Fill: "none"}))
+	assert.NilError(t, service.ValidateQueryOptions(models.QueryOptions{Aggregate: "count"}))
+
+	assert.Error(t, service.ValidateQueryOptions(models.QueryOptions{Aggregate: "drop"}), "`aggregate` parameter must be one of")
+	assert.Error(t, service.ValidateQueryOptions(models.QueryOptions{GroupBy: "1h"}), "requires an `aggregate`")
+	assert.Error(t, service.ValidateQueryOptions(models.QueryOptions{Aggregate: "max", GroupBy: "1 hour"}), "must be a duration")
+	assert.Error(t, service.ValidateQueryOptions(models.QueryOptions{Aggregate: "max", Fill: "0"}), "requires a `groupBy`")
+	assert.Error(t, service.ValidateQueryOptions(models.QueryOptions{Aggregate: "max", GroupBy: "1h", Fill: "zero"}), "`fill` parameter must be")
+}
+
+func TestValidateQueryOptionsLimit(t *testing.T) {
+	service := &ConsumerService{maxRows: 100}
+
+	assert.NilError(t, service.ValidateQueryOptions(models.QueryOptions{Limit: 100}))
+	assert.Error(t, service.ValidateQueryOptions(models.QueryOptions{Limit: 101}), "between 1 and 100")
+	assert.Error(t, service.ValidateQueryOptions(models.QueryOptions{Limit: -1}), "between 1 and 100")
+}
+
+func TestGetLatestPointsRequiresATag(t *testing.T) {
+	service := new(ConsumerService)
+
+	_, servErr := service.GetLatestPoints(&models.Data{Tags: map[string]string{"owner": "+", "thing": "+", "node": "+"}})
+	assert.Equal(t, servErr.Invalid, true)
+	assert.Error(t, servErr.Err, "must be provided")
+}
+
+func TestCreatePointRejectsInvalidPoints(t *testing.T) {
+	_, servErr := new(ConsumerService).CreatePoint(&models.Data{Tags: map[string]string{"owner": "movbb", "thing": "297145674599", "node": "location"}})
+	assert.Equal(t, servErr.Invalid, true)
+	assert.NotNil(t, servErr.Err)
+}
+
+func TestDeletePointsRequiresATag(t *testing.T) {
+	_, servErr := new(ConsumerService).DeletePoints(&models.Data{Tags: map[string]string{"owner": "+", "thing": "+", "node": "+"}}, true, false)
+	assert.Equal(t, servErr.Invalid, true)
+	assert.Error(t, servErr.Err, "must be provided")
+}
+
+func TestDeletePointsRequiresAPeriodOrAll(t *testing.T) {
+	_, servErr := new(ConsumerService).DeletePoints(&models.Data{Tags: map[string]string{"owner": "movbb", "thing": "+", "node": "+"}}, false, false)
+	assert.Equal(t, servErr.Invalid, true)
+	assert.Error(t, servErr.Err, "all=true")
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+	"github.com/labbsr0x/kafka2influxdb/web/utils"
+
+	client "github.com/influxdata/influxdb1-client/v2"
+)
+
+// ExportFormats maps the export formats to their content types
+var ExportFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"line":   "text/plain; charset=utf-8",
+}
+
+// exportMediaTypes maps the media types accepted in the Accept header to the export formats
+var exportMediaTypes = map[string]string{
+	"text/csv":             "csv",
+	"application/x-ndjson": "ndjson",
+	"application/jsonl":    "ndjson",
+	"text/plain":           "line",
+}
+
+// ExportFormat picks the export format from the `format` param or, when it is missing,
+// from the first known media type of the Accept header. NDJSON is the default
+func ExportFormat(format string, accept string) (string, error) {
+	if format != "" {
+		format = strings.ToLower(format)
+		if _, ok := ExportFormats[format]; !ok {
+			return "", fmt.Errorf("The `format` parameter must be one of csv, ndjson or line. Got: %s", format)
+		}
+		return format, nil
+	}
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		if format, ok := exportMediaTypes[mediaType]; ok {
+			return format, nil
+		}
+	}
+	return "ndjson", nil
+}
+
+// ExportPoints streams the points matching the query to w in one of the ExportFormats. The
+// columns are the owner, thing and node tags plus the requested fields, or every persisted
+// field when none is requested
+func (s *ConsumerService) ExportPoints(data *models.Data, format string, w io.Writer) (servErr utils.ServiceError) {
+	if err := s.ValidateQueryParams(data); err != nil {
+		servErr.Invalid = true
+		servErr.Err = err
+		return
+	}
+
+	// the line protocol tells the fields apart from the tags read as columns
+	var fieldTypes map[string]string
+	fields := data.Options.Fields
+	if len(fields) == 0 || format == "line" {
+		var err error
+		if fieldTypes, err = s.repo.FieldKeys(); err != nil {
+			servErr.Internal = true
+			servErr.Err = err
+			return
+		}
+	}
+	if len(fields) == 0 {
+		for field := range fieldTypes {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+	}
+
+	encoder := newPointEncoder(format, w, fields, fieldTypes)
+	err := s.repo.ExportPoints(data, encoder.Encode)
+	if err == nil {
+		err = encoder.Flush()
+	}
+	if err != nil {
+		servErr.Internal = true
+		servErr.Err = err
+	}
+	return
+}
+
+// pointEncoder writes the exported points in one of the ExportFormats
+type pointEncoder interface {
+	Encode(point models.StatePoint) error
+	Flush() error
+}
+
+func newPointEncoder(format string, w io.Writer, fields []string, fieldTypes map[string]string) pointEncoder {
+	switch format {
+	case "csv":
+		return &csvEncoder{writer: csv.NewWriter(w), fields: fields}
+	case "line":
+		return &lineEncoder{writer: bufio.NewWriter(w), fieldTypes: fieldTypes}
+	default:
+		return &ndjsonEncoder{encoder: json.NewEncoder(w)}
+	}
+}
+
+// csvEncoder writes a header with the time, tags and fields, followed by a row per point
+type csvEncoder struct {
+	writer  *csv.Writer
+	fields  []string
+	started bool
+}
+
+func (e *csvEncoder) Encode(point models.StatePoint) error {
+	if err := e.header(); err != nil {
+		return err
+	}
+
+	row := []string{point.DateTime.UTC().Format(time.RFC3339Nano), point.Owner, point.Thing, point.Node}
+	for _, field := range e.fields {
+		value, ok := point.Attributes[field]
+		if !ok || value == nil {
+			row = append(row, "")
+		} else {
+			row = append(row, fmt.Sprint(value))
+		}
+	}
+	return e.writer.Write(row)
+}
+
+func (e *csvEncoder) Flush() error {
+	if err := e.header(); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// header writes the column names once, even when there are no points
+func (e *csvEncoder) header() error {
+	if e.started {
+		return nil
+	}
+	e.started = true
+	return e.writer.Write(append([]string{"time", "owner", "thing", "node"}, e.fields...))
+}
+
+// ndjsonEncoder writes each point as a JSON document in its own line
+type ndjsonEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e *ndjsonEncoder) Encode(point models.StatePoint) error {
+	return e.encoder.Encode(point)
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return nil
+}
+
+// lineEncoder writes the points in InfluxDB line protocol, so they can be imported back. The
+// attributes that aren't fields of the state measurement are the tags read as columns
+type lineEncoder struct {
+	writer     *bufio.Writer
+	fieldTypes map[string]string
+}
+
+func (e *lineEncoder) Encode(point models.StatePoint) error {
+	tags := map[string]string{}
+	for name, value := range point.Tags {
+		tags[name] = value
+	}
+	fields := map[string]interface{}{}
+	for name, value := range point.Attributes {
+		if _, ok := e.fieldTypes[name]; ok {
+			fields[name] = value
+		} else if value != nil {
+			tags[name] = fmt.Sprint(value)
+		}
+	}
+	tags["owner"], tags["thing"], tags["node"] = point.Owner, point.Thing, point.Node
+
+	// line protocol requires at least one field
+	if len(fields) == 0 {
+		return nil
+	}
+
+	p, err := client.NewPoint("state", tags, fields, point.DateTime)
+	if err != nil {
+		return err
+	}
+	_, err = e.writer.WriteString(p.String() + "\n")
+	return err
+}
+
+func (e *lineEncoder) Flush() error {
+	return e.writer.Flush()
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestExportFormat(t *testing.T) {
+	format, err := ExportFormat("CSV", "application/x-ndjson")
+	assert.NilError(t, err)
+	assert.Equal(t, format, "csv")
+
+	format, err = ExportFormat("", "application/json;q=0.9, text/plain;q=0.5")
+	assert.NilError(t, err)
+	assert.Equal(t, format, "line")
+
+	format, err = ExportFormat("", "*/*")
+	assert.NilError(t, err)
+	assert.Equal(t, format, "ndjson")
+
+	_, err = ExportFormat("xml", "")
+	assert.Error(t, err, "must be one of csv, ndjson or line")
+}
+
+func TestPointEncoders(t *testing.T) {
+	point := models.StatePoint{
+		Owner:      "movbb",
+		Thing:      "297145674599",
+		Node:       "location",
+		Attributes: map[string]interface{}{"lat": -5.52, "type": "gps, fixed", "satellites": int64(7), "schema_0": "movbb"},
+		DateTime:   time.Date(2020, time.April, 8, 0, 4, 8, 0, time.UTC),
+	}
+
+	expected := map[string]string{
+		"csv": "time,owner,thing,node,lat,lon,type\n" +
+			"2020-04-08T00:04:08Z,movbb,297145674599,location,-5.52,,\"gps, fixed\"\n",
+		"ndjson": `{"owner":"movbb","thing":"297145674599","node":"location","attributes":{"lat":-5.52,"satellites":7,"schema_0":"movbb","type":"gps, fixed"},"dateTime":"2020-04-08T00:04:08Z"}` + "\n",
+		"line":   `state,node=location,owner=movbb,schema_0=movbb,thing=297145674599 lat=-5.52,satellites=7i,type="gps, fixed" 1586304248000000000` + "\n",
+	}
+	fieldTypes := map[string]string{"lat": "float", "lon": "float", "satellites": "integer", "type": "string"}
+
+	for format, output := range expected {
+		var b bytes.Buffer
+		encoder := newPointEncoder(format, &b, []string{"lat", "lon", "type"}, fieldTypes)
+		assert.NilError(t, encoder.Encode(point))
+		assert.NilError(t, encoder.Flush())
+		assert.Equal(t, b.String(), output)
+	}
+
+	var b bytes.Buffer
+	assert.NilError(t, newPointEncoder("csv", &b, []string{"lat"}, nil).Flush())
+	assert.Equal(t, b.String(), "time,owner,thing,node,lat\n")
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+)
+
+// GeoJSONContentType is the media type of GeoJSON documents
+const GeoJSONContentType = "application/geo+json"
+
+// FeatureCollection is a GeoJSON feature collection
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a GeoJSON Point, with a single position, or LineString, with many
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// ToGeoJSON converts the location points to GeoJSON. The `point` geometry makes a Point per
+// state point, with the other attributes as properties, and the `track` geometry makes a
+// LineString per owner/thing/node, ordered by time. A track with a single position is a Point, as
+// a LineString needs two. Points without a valid lat/lon are skipped
+func ToGeoJSON(points []models.StatePoint, geometry string) (*FeatureCollection, error) {
+	collection := &FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+
+	switch geometry {
+	case "", "point":
+		for _, point := range points {
+			position, ok := position(point)
+			if !ok {
+				continue
+			}
+
+			properties := map[string]interface{}{
+				"owner":    point.Owner,
+				"thing":    point.Thing,
+				"node":     point.Node,
+				"dateTime": point.DateTime,
+			}
+			for name, value := range point.Attributes {
+				if name != "lat" && name != "lon" {
+					properties[name] = value
+				}
+			}
+			collection.Features = append(collection.Features, Feature{
+				Type:       "Feature",
+				Geometry:   Geometry{Type: "Point", Coordinates: position},
+				Properties: properties,
+			})
+		}
+
+	case "track":
+		tracks := map[[3]string][]models.StatePoint{}
+		keys := [][3]string{}
+		for _, point := range points {
+			key := [3]string{point.Owner, point.Thing, point.Node}
+			if _, found := tracks[key]; !found {
+				keys = append(keys, key)
+			}
+			tracks[key] = append(tracks[key], point)
+		}
+
+		for _, key := range keys {
+			track := tracks[key]
+			sort.SliceStable(track, func(i, j int) bool { return track[i].DateTime.Before(track[j].DateTime) })
+
+			coordinates := [][]float64{}
+			times := []time.Time{}
+			for _, point := range track {
+				if position, ok := position(point); ok {
+					coordinates = append(coordinates, position)
+					times = append(times, point.DateTime)
+				}
+			}
+			if len(coordinates) == 0 {
+				continue
+			}
+
+			geometry := Geometry{Type: "LineString", Coordinates: coordinates}
+			if len(coordinates) == 1 {
+				geometry = Geometry{Type: "Point", Coordinates: coordinates[0]}
+			}
+			collection.Features = append(collection.Features, Feature{
+				Type:     "Feature",
+				Geometry: geometry,
+				Properties: map[string]interface{}{
+					"owner":      key[0],
+					"thing":      key[1],
+					"node":       key[2],
+					"coordTimes": times,
+				},
+			})
+		}
+
+	default:
+		return nil, fmt.Errorf("The `geometry` parameter must be point or track. Got: %s", geometry)
+	}
+
+	return collection, nil
+}
+
+// position reads the [lon, lat] position of a point, as GeoJSON orders them
+func position(point models.StatePoint) ([]float64, bool) {
+	lat, ok := coordinate(point.Attributes["lat"])
+	if !ok || lat < -90 || lat > 90 {
+		return nil, false
+	}
+	lon, ok := coordinate(point.Attributes["lon"])
+	if !ok || lon < -180 || lon > 180 {
+		return nil, false
+	}
+	return []float64{lon, lat}, true
+}
+
+// coordinate reads a coordinate persisted as a number or, by legacy schemas, as a string
+func coordinate(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func newLocation(thing string, minute int, lat interface{}, lon interface{}) models.StatePoint {
+	return models.StatePoint{
+		Owner:      "movbb",
+		Thing:      thing,
+		Node:       "location",
+		Attributes: map[string]interface{}{"lat": lat, "lon": lon, "type": "gps"},
+		DateTime:   time.Date(2020, time.April, 8, 0, minute, 0, 0, time.UTC),
+	}
+}
+
+func TestToGeoJSONPoints(t *testing.T) {
+	collection, err := ToGeoJSON([]models.StatePoint{
+		newLocation("abc1234", 1, "-5.5222581", "-47.4573297"),
+		newLocation("abc1234", 2, json.Number("-5.53"), -47.46),
+		newLocation("abc1234", 3, "unknown", "-47.46"),
+		newLocation("abc1234", 4, 95.0, -47.46),
+	}, "")
+	assert.NilError(t, err)
+	assert.Equal(t, len(collection.Features), 2)
+
+	feature := collection.Features[0]
+	assert.Equal(t, feature.Geometry.Type, "Point")
+	assert.DeepEqual(t, feature.Geometry.Coordinates, []float64{-47.4573297, -5.5222581})
+	assert.Equal(t, feature.Properties["type"], "gps")
+	assert.Equal(t, feature.Properties["thing"], "abc1234")
+	_, found := feature.Properties["lat"]
+	assert.Equal(t, found, false)
+}
+
+func TestToGeoJSONTracks(t *testing.T) {
+	collection, err := ToGeoJSON([]models.StatePoint{
+		newLocation("abc1234", 3, -5.3, -47.3),
+		newLocation("def5678", 1, -6.1, -48.1),
+		newLocation("abc1234", 1, -5.1, -47.1),
+		newLocation("abc1234", 2, -5.2, -47.2),
+	}, "track")
+	assert.NilError(t, err)
+	assert.Equal(t, len(collection.Features), 2)
+
+	track := collection.Features[0]
+	assert.Equal(t, track.Geometry.Type, "LineString")
+	assert.Equal(t, track.Properties["thing"], "abc1234")
+	assert.DeepEqual(t, track.Geometry.Coordinates, [][]float64{{-47.1, -5.1}, {-47.2, -5.2}, {-47.3, -5.3}})
+	assert.Equal(t, len(track.Properties["coordTimes"].([]time.Time)), 3)
+
+	// a single position is not a valid LineString
+	single := collection.Features[1]
+	assert.Equal(t, single.Geometry.Type, "Point")
+	assert.Equal(t, single.Properties["thing"], "def5678")
+	assert.DeepEqual(t, single.Geometry.Coordinates, []float64{-48.1, -6.1})
+
+	_, err = ToGeoJSON(nil, "polygon")
+	assert.Error(t, err, "must be point or track")
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Check tells whether a dependency is ready, returning why it isn't
+type Check func() error
+
+// CheckResult is the state of a dependency
+type CheckResult struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+// HealthService runs the readiness checks of the dependencies
+type HealthService struct {
+	timeout time.Duration
+	lock    sync.RWMutex
+	checks  map[string]Check
+}
+
+// NewHealthService creates a service whose checks are down when they take longer than the timeout
+func NewHealthService(timeout time.Duration) *HealthService {
+	return &HealthService{timeout: timeout, checks: map[string]Check{}}
+}
+
+// Register adds the check of a dependency
+func (s *HealthService) Register(name string, check Check) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.checks[name] = check
+}
+
+// Ready runs every check at once and tells whether all of them are up
+func (s *HealthService) Ready() (bool, map[string]CheckResult) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]CheckResult{}
+	ready := true
+
+	for name, check := range s.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := s.run(check)
+			result := CheckResult{Status: "up", Latency: time.Since(start).String()}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			results[name] = result
+			ready = ready && err == nil
+		}(name, check)
+	}
+
+	wg.Wait()
+	return ready, results
+}
+
+// run gives up waiting for a check after the timeout, so a hanging dependency can't hang the probe
+func (s *HealthService) run(check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(s.timeout):
+		return fmt.Errorf("The check didn't finish in %s", s.timeout)
+	}
+}
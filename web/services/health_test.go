@@ -0,0 +1,48 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestHealthServiceReady(t *testing.T) {
+	health := NewHealthService(time.Second)
+	health.Register("influxdb", func() error { return nil })
+	health.Register("kafka", func() error { return nil })
+
+	ready, checks := health.Ready()
+	assert.Equal(t, ready, true)
+	assert.Equal(t, len(checks), 2)
+	assert.Equal(t, checks["influxdb"].Status, "up")
+	assert.Equal(t, checks["kafka"].Status, "up")
+}
+
+func TestHealthServiceNotReady(t *testing.T) {
+	health := NewHealthService(time.Second)
+	health.Register("influxdb", func() error { return nil })
+	health.Register("kafka", func() error { return fmt.Errorf("No broker is reachable") })
+
+	ready, checks := health.Ready()
+	assert.Equal(t, ready, false)
+	assert.Equal(t, checks["influxdb"].Status, "up")
+	assert.Equal(t, checks["kafka"].Status, "down")
+	assert.Equal(t, checks["kafka"].Error, "No broker is reachable")
+}
+
+func TestHealthServiceTimeout(t *testing.T) {
+	health := NewHealthService(10 * time.Millisecond)
+	hang := make(chan struct{})
+	defer close(hang)
+	health.Register("schemaRegistry", func() error {
+		<-hang
+		return nil
+	})
+
+	ready, checks := health.Ready()
+	assert.Equal(t, ready, false)
+	assert.Equal(t, checks["schemaRegistry"].Status, "down")
+	assert.Error(t, fmt.Errorf(checks["schemaRegistry"].Error), "didn't finish")
+}
@@ -3,20 +3,32 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/labbsr0x/kafka2influxdb/web/config"
+	"github.com/labbsr0x/kafka2influxdb/web/metrics"
 
 	"github.com/sirupsen/logrus"
 	"github.com/valyala/fasthttp"
 )
 
+// schema is a schema loaded from the registry
+type schema struct {
+	model string
+	name  string
+}
+
 type KafkaService struct {
 	schemaRegistry string
+	lock           sync.RWMutex
+	schemas        map[int32]schema
 }
 
 func NewKafkaService(webBuilder *config.WebBuilder) *KafkaService {
 	instance := new(KafkaService)
 	instance.schemaRegistry = webBuilder.KafkaSchemaRegistry
+	instance.schemas = map[int32]schema{}
 	return instance
 }
 
@@ -48,8 +60,30 @@ func (s *KafkaService) GetSchemaID(topicName string) (int64, error) {
 	return schemaID, nil
 }
 
-//LoadSchemaFromRegistry .
+//LoadSchemaFromRegistry gets a schema by id. The registry never changes the schema of an id,
+//so each one is requested only once.
 func (s *KafkaService) LoadSchemaFromRegistry(schemaID int32) (string, string, error) {
+	s.lock.RLock()
+	cached, found := s.schemas[schemaID]
+	s.lock.RUnlock()
+	metrics.SchemaCacheLookup(found)
+	if found {
+		return cached.model, cached.name, nil
+	}
+
+	model, name, err := s.fetchSchema(schemaID)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.lock.Lock()
+	s.schemas[schemaID] = schema{model: model, name: name}
+	s.lock.Unlock()
+	return model, name, nil
+}
+
+//fetchSchema requests a schema by id from the registry.
+func (s *KafkaService) fetchSchema(schemaID int32) (string, string, error) {
 	url := fmt.Sprintf("%s/schemas/ids/%d", s.schemaRegistry, schemaID)
 	res, err := doGet([]byte(url), "application/json")
 	if err != nil {
@@ -73,6 +107,23 @@ func (s *KafkaService) LoadSchemaFromRegistry(schemaID int32) (string, string, e
 	return "", "", fmt.Errorf("Error on aquire Schema ID %d", schemaID)
 }
 
+//PingRegistry checks that the schema registry answers within the timeout.
+func (s *KafkaService) PingRegistry(timeout time.Duration) error {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.SetRequestURI(fmt.Sprintf("%s/subjects", s.schemaRegistry))
+	if err := fasthttp.DoTimeout(req, res, timeout); err != nil {
+		return fmt.Errorf("The schema registry can't be reached: %s", err)
+	}
+	if res.StatusCode() != fasthttp.StatusOK {
+		return fmt.Errorf("The schema registry answered with status %d", res.StatusCode())
+	}
+	return nil
+}
+
 //doGet do a http get request.
 func doGet(url []byte, contentType string) (*fasthttp.Response, error) {
 	logrus.Infof("URL: %s", string(url))
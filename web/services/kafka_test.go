@@ -2,8 +2,13 @@ package services
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"encoding/json"
+
+	"github.com/docker/docker/pkg/testutil/assert"
 )
 
 func TestGetPayloadData(t *testing.T){
@@ -15,4 +20,23 @@ func TestGetPayloadData(t *testing.T){
 		_ = json.Unmarshal([]byte(response["schema"].(string)), &response)
 	}
 	fmt.Println(response)
-}
\ No newline at end of file
+}
+
+func TestLoadSchemaFromRegistryCachesSchemas(t *testing.T) {
+	var requests int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		assert.Equal(t, r.URL.Path, "/schemas/ids/7")
+		fmt.Fprint(w, `{"schema":"{\"type\":\"record\",\"name\":\"movbb\",\"fields\":[]}"}`)
+	}))
+	defer registry.Close()
+
+	service := &KafkaService{schemaRegistry: registry.URL, schemas: map[int32]schema{}}
+	for i := 0; i < 3; i++ {
+		model, name, err := service.LoadSchemaFromRegistry(7)
+		assert.NilError(t, err)
+		assert.Equal(t, name, "movbb")
+		assert.Equal(t, model, `{"type":"record","name":"movbb","fields":[]}`)
+	}
+	assert.Equal(t, atomic.LoadInt32(&requests), int32(1))
+}
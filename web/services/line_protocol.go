@@ -0,0 +1,47 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	influxmodels "github.com/influxdata/influxdb1-client/models"
+)
+
+// linePrecisions maps the precisions of the InfluxDB /write endpoint to the parser ones
+var linePrecisions = map[string]string{"": "n", "n": "n", "ns": "n", "u": "u", "ms": "ms", "s": "s", "m": "m", "h": "h"}
+
+// ParseLineProtocol reads the points of an InfluxDB line protocol body. Only the `state`
+// measurement is accepted, as it is the one the points are written to. Points without a
+// timestamp are taken as collected now
+func ParseLineProtocol(body []byte, precision string) ([]*models.Data, error) {
+	parserPrecision, ok := linePrecisions[precision]
+	if !ok {
+		return nil, fmt.Errorf("invalid precision %q (use n, u, ms, s, m or h)", precision)
+	}
+
+	points, err := influxmodels.ParsePointsWithPrecision(body, time.Now().UTC(), parserPrecision)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*models.Data, len(points))
+	for i, point := range points {
+		if name := string(point.Name()); name != "state" {
+			return nil, fmt.Errorf("unable to write measurement %q: only the state measurement is accepted", name)
+		}
+
+		fields, err := point.Fields()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the fields of '%s': %s", point.String(), err)
+		}
+
+		items[i] = &models.Data{
+			DateTime: point.Time(),
+			Tags:     point.Tags().Map(),
+			Fields:   fields,
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestParseLineProtocol(t *testing.T) {
+	body := []byte("# gateway backfill\n" +
+		"state,owner=movbb,thing=297145674599,node=location lat=-5.52,satellites=7i,type=\"gps\" 1586304248\n" +
+		"state,owner=movbb,thing=297145674599,node=location $name=\"truck\"\n")
+
+	points, err := ParseLineProtocol(body, "s")
+	assert.NilError(t, err)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, points[0].DateTime, time.Date(2020, time.April, 8, 0, 4, 8, 0, time.UTC))
+	assert.DeepEqual(t, points[0].Tags, map[string]string{"owner": "movbb", "thing": "297145674599", "node": "location"})
+	assert.DeepEqual(t, points[0].Fields, map[string]interface{}{"lat": -5.52, "satellites": int64(7), "type": "gps"})
+
+	// the `$` static attributes are rejected when the point is written, as in the other endpoints
+	_, found := points[1].Fields["$name"]
+	assert.Equal(t, found, true)
+	assert.Equal(t, points[1].DateTime.IsZero(), false)
+
+	_, err = ParseLineProtocol([]byte("state,owner=movbb lat="), "")
+	assert.Error(t, err, "unable to parse")
+
+	_, err = ParseLineProtocol([]byte("cpu,host=a value=1"), "")
+	assert.Error(t, err, "only the state measurement")
+
+	_, err = ParseLineProtocol(body, "d")
+	assert.Error(t, err, "invalid precision")
+}
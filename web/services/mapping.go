@@ -0,0 +1,298 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultKeyPattern is the key layout used when none is configured
+const defaultKeyPattern = "owner/{owner}/thing/{thing}/node/{node}"
+
+type MappingService struct {
+	mappings   *config.MappingConfig
+	keyPattern *regexp.Regexp
+	headerTags []string
+}
+
+func NewMappingService(webBuilder *config.WebBuilder) *MappingService {
+	instance := new(MappingService)
+	instance.mappings = webBuilder.Mappings
+	if instance.mappings == nil {
+		instance.mappings = config.DefaultMappingConfig()
+	}
+
+	pattern := defaultKeyPattern
+	if webBuilder.Flags != nil {
+		if webBuilder.KafkaKeyPattern != "" {
+			pattern = webBuilder.KafkaKeyPattern
+		}
+		for _, header := range strings.Split(webBuilder.KafkaHeaderTags, ",") {
+			if header = strings.TrimSpace(header); header != "" {
+				instance.headerTags = append(instance.headerTags, header)
+			}
+		}
+	}
+
+	keyPattern, err := CompileKeyPattern(pattern)
+	if err != nil {
+		panic(err.Error())
+	}
+	instance.keyPattern = keyPattern
+
+	return instance
+}
+
+// CompileKeyPattern compiles a key template, where every `{name}` placeholder captures one path
+// segment (Ex: "tenant/{tenant}/site/{site}"), or a regex with named groups (Ex: "(?P<thing>\d+)$")
+func CompileKeyPattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.Contains(pattern, "(?P<") {
+		placeholder := regexp.MustCompile(`\{(\w+)\}`)
+		expr := ""
+		last := 0
+		for _, loc := range placeholder.FindAllStringSubmatchIndex(pattern, -1) {
+			expr += regexp.QuoteMeta(pattern[last:loc[0]]) + "(?P<" + pattern[loc[2]:loc[3]] + ">[^/]+)"
+			last = loc[1]
+		}
+		pattern = expr + regexp.QuoteMeta(pattern[last:])
+	}
+
+	keyPattern, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key pattern `%s`: %s", pattern, err)
+	}
+	return keyPattern, nil
+}
+
+// KeyTags returns the tags captured by the named groups of the key pattern.
+// It fails when the key doesn't match the pattern
+func (s *MappingService) KeyTags(key string) (map[string]string, error) {
+	matches := s.keyPattern.FindStringSubmatch(key)
+	if matches == nil {
+		return nil, fmt.Errorf("The key doesn't match the pattern `%s`: %s", s.keyPattern, key)
+	}
+
+	tags := map[string]string{}
+	for i, name := range s.keyPattern.SubexpNames() {
+		if name != "" && matches[i] != "" {
+			tags[name] = matches[i]
+		}
+	}
+	return tags, nil
+}
+
+// HeaderTags returns the configured headers found in a message as tags
+func (s *MappingService) HeaderTags(headers map[string]string) map[string]string {
+	tags := map[string]string{}
+	for _, name := range s.headerTags {
+		if value, ok := headers[name]; ok && value != "" {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
+// Map turns a decoded record into the date time, tags and fields of a data, following the
+// mapping configured for its topic or schema
+func (s *MappingService) Map(topic string, schema string, record map[string]interface{}) (*models.Data, error) {
+	mapping := s.mappings.Lookup(topic, schema)
+
+	rawDateTime, ok := lookupPath(record, mapping.Timestamp)
+	if !ok {
+		return nil, fmt.Errorf("The timestamp attribute `%s` was not found in the record", mapping.Timestamp)
+	}
+	dateTime, err := parseTimestamp(rawDateTime)
+	if err != nil {
+		return nil, fmt.Errorf("Error on parse `%s`: %s", mapping.Timestamp, err)
+	}
+
+	data := new(models.Data)
+	data.DateTime = dateTime
+	data.Tags = map[string]string{}
+	data.Fields = map[string]interface{}{}
+
+	skip := map[string]bool{mapping.Timestamp: true}
+	for _, path := range mapping.Drop {
+		skip[path] = true
+	}
+
+	for _, path := range mapping.Tags {
+		skip[path] = true
+		if value, ok := lookupPath(record, path); ok && value != nil {
+			data.Tags[attributeName(path)] = fmt.Sprint(value)
+		}
+	}
+
+	fields := mapping.Fields
+	if len(fields) == 0 {
+		for name := range record {
+			fields = append(fields, name)
+		}
+	}
+
+	for _, path := range fields {
+		if skip[path] {
+			continue
+		}
+		value, ok := lookupPath(record, path)
+		if !ok || value == nil {
+			continue
+		}
+		if _, nested := value.(map[string]interface{}); nested {
+			logrus.Debugf("Skipping nested record `%s`. Map its attributes by path to persist them", path)
+			continue
+		}
+		if _, list := value.([]interface{}); list {
+			logrus.Debugf("Skipping array `%s` as it can't be persisted as a field", path)
+			continue
+		}
+		data.Fields[attributeName(path)] = normalizeValue(value)
+	}
+
+	if err := coerceFields(mapping, data.Fields); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Coerce normalizes the numbers of the fields, like the ones decoded from JSON, and converts
+// them following the coercion rules of the mapping of a topic or schema
+func (s *MappingService) Coerce(topic string, schema string, fields map[string]interface{}) error {
+	for name, value := range fields {
+		fields[name] = normalizeValue(value)
+	}
+	return coerceFields(s.mappings.Lookup(topic, schema), fields)
+}
+
+func coerceFields(mapping config.Mapping, fields map[string]interface{}) error {
+	for name, kind := range mapping.Coerce {
+		value, ok := fields[name]
+		if !ok || value == nil {
+			continue
+		}
+		coerced, err := coerce(value, kind)
+		if err != nil {
+			return fmt.Errorf("Error converting field `%s` to %s: %s", name, kind, err)
+		}
+		fields[name] = coerced
+	}
+	return nil
+}
+
+// normalizeValue converts the decoded numbers to the int64 and float64 types stored by InfluxDB.
+// JSON numbers without decimal places or exponent are integers
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	}
+	return value
+}
+
+// coerce converts a value to `float`, `integer`, `boolean` or `string`
+func coerce(value interface{}, kind string) (interface{}, error) {
+	value = normalizeValue(value)
+
+	switch kind {
+	case "string":
+		return fmt.Sprint(value), nil
+
+	case "float":
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(v), 64)
+		}
+
+	case "integer":
+		switch v := value.(type) {
+		case int64:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v), nil
+			}
+			return nil, fmt.Errorf("%v has decimal places", v)
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		}
+
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(v))
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown type %s", kind)
+	}
+
+	return nil, fmt.Errorf("%v of type %T can't be converted", value, value)
+}
+
+// lookupPath walks the nested records of a dot separated path
+func lookupPath(record map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = record
+	for _, name := range strings.Split(path, ".") {
+		values, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = values[name]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// attributeName names the tag or field of a path, joining nested names with `_`
+func attributeName(path string) string {
+	return strings.ReplaceAll(path, ".", "_")
+}
+
+// parseTimestamp accepts RFC3339 strings, times and epoch milliseconds
+func parseTimestamp(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		dateTime, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Parse("2006-01-02T15:04:05Z0700", v)
+		}
+		return dateTime, nil
+	case int:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)).UTC(), nil
+	case int64:
+		return time.Unix(0, v*int64(time.Millisecond)).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("Unsupported timestamp %v of type %T", value, value)
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestMapKeepsEveryTopLevelAttributeByDefault(t *testing.T) {
+	service := NewMappingService(&config.WebBuilder{})
+	record := map[string]interface{}{
+		"dateTime": "2020-04-08T00:23:00Z",
+		"lat":      "-22.7198683",
+		"lon":      "-47.6513981",
+		"battery":  87,
+		"position": map[string]interface{}{"alt": 12.5},
+	}
+
+	data, err := service.Map("owner-movbb", "movbb", record)
+	assert.NilError(t, err)
+	assert.Equal(t, data.DateTime, time.Date(2020, time.April, 8, 0, 23, 0, 0, time.UTC))
+	assert.DeepEqual(t, data.Fields, map[string]interface{}{"lat": "-22.7198683", "lon": "-47.6513981", "battery": int64(87)})
+	assert.Equal(t, len(data.Tags), 0)
+}
+
+func TestMapFollowsTopicMapping(t *testing.T) {
+	mappings := config.DefaultMappingConfig()
+	mappings.Schemas = map[string]config.Mapping{"movbb": {Drop: []string{"lon"}}}
+	mappings.Topics = map[string]config.Mapping{
+		"owner-sensors": {
+			Tags:      []string{"type"},
+			Fields:    []string{"position.alt", "lat"},
+			Timestamp: "collectedAt",
+		},
+	}
+	service := NewMappingService(&config.WebBuilder{Mappings: mappings})
+
+	record := map[string]interface{}{
+		"collectedAt": int64(1586305380000),
+		"type":        "gps",
+		"lat":         "-22.7198683",
+		"lon":         "-47.6513981",
+		"position":    map[string]interface{}{"alt": 12.5},
+	}
+	data, err := service.Map("owner-sensors", "movbb", record)
+	assert.NilError(t, err)
+	assert.Equal(t, data.DateTime, time.Date(2020, time.April, 8, 0, 23, 0, 0, time.UTC))
+	assert.DeepEqual(t, data.Tags, map[string]string{"type": "gps"})
+	assert.DeepEqual(t, data.Fields, map[string]interface{}{"position_alt": 12.5, "lat": "-22.7198683"})
+
+	// without a topic mapping, the schema one is used
+	record["dateTime"] = "2020-04-08T00:23:00Z"
+	data, err = service.Map("owner-movbb", "movbb", record)
+	assert.NilError(t, err)
+	_, found := data.Fields["lon"]
+	assert.Equal(t, found, false)
+
+	delete(record, "dateTime")
+	_, err = service.Map("owner-movbb", "movbb", record)
+	assert.Error(t, err, "`dateTime` was not found")
+}
+
+func TestKeyTags(t *testing.T) {
+	service := NewMappingService(&config.WebBuilder{})
+	tags, err := service.KeyTags("owner/movbb/thing/297145674599/node/location")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, tags, map[string]string{"owner": "movbb", "thing": "297145674599", "node": "location"})
+
+	_, err = service.KeyTags("297145674599")
+	assert.Error(t, err, "doesn't match")
+
+	service = NewMappingService(&config.WebBuilder{Flags: &config.Flags{
+		KafkaKeyPattern: "tenant/{owner}/site/{site}/device/{thing}.v1",
+		KafkaHeaderTags: "node, region",
+	}})
+	tags, err = service.KeyTags("tenant/movbb/site/sp-01/device/abc-1234.v1")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, tags, map[string]string{"owner": "movbb", "site": "sp-01", "thing": "abc-1234"})
+	assert.DeepEqual(t, service.HeaderTags(map[string]string{"node": "location", "trace": "x"}), map[string]string{"node": "location"})
+
+	service = NewMappingService(&config.WebBuilder{Flags: &config.Flags{KafkaKeyPattern: `^(?P<owner>[a-z]+):(?P<thing>\d+)$`}})
+	tags, err = service.KeyTags("movbb:297145674599")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, tags, map[string]string{"owner": "movbb", "thing": "297145674599"})
+
+	_, err = CompileKeyPattern("(?P<owner>[a-z")
+	assert.NotNil(t, err)
+}
+
+func TestMapCoercesLegacyStringFields(t *testing.T) {
+	mappings := config.DefaultMappingConfig()
+	mappings.Default.Coerce = map[string]string{"lat": "float", "lon": "float", "satellites": "integer", "moving": "boolean", "mci": "string"}
+	service := NewMappingService(&config.WebBuilder{Mappings: mappings})
+
+	record := map[string]interface{}{
+		"dateTime":   "2020-04-08T00:23:00Z",
+		"lat":        "-22.7198683",
+		"lon":        float32(-47.5),
+		"satellites": "7",
+		"moving":     "true",
+		"mci":        int64(186220680922),
+		"speed":      int32(40),
+	}
+	data, err := service.Map("owner-movbb", "movbb", record)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, data.Fields, map[string]interface{}{
+		"lat":        -22.7198683,
+		"lon":        -47.5,
+		"satellites": int64(7),
+		"moving":     true,
+		"mci":        "186220680922",
+		"speed":      int64(40),
+	})
+
+	record["lat"] = "unknown"
+	_, err = service.Map("owner-movbb", "movbb", record)
+	assert.Error(t, err, "Error converting field `lat` to float")
+
+	fields := map[string]interface{}{"satellites": 7.5}
+	assert.Error(t, service.Coerce("", "", fields), "decimal places")
+}
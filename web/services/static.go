@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+	"github.com/labbsr0x/kafka2influxdb/web/config"
+	"github.com/labbsr0x/kafka2influxdb/web/repositories"
+	"github.com/labbsr0x/kafka2influxdb/web/utils"
+)
+
+type StaticService struct {
+	repo *repositories.StaticRepository
+}
+
+func NewStaticService(webBuilder *config.WebBuilder) *StaticService {
+	instance := new(StaticService)
+	instance.repo = repositories.NewStaticRepository(webBuilder)
+	return instance
+}
+
+//GetAttributes gets the static attributes of a node
+func (s *StaticService) GetAttributes(tags map[string]string) (attributes map[string]interface{}, servErr utils.ServiceError) {
+	if err := validateStaticTags(tags); err != nil {
+		servErr.Invalid = true
+		servErr.Err = err
+		return
+	}
+
+	attributes, err := s.repo.GetAttributes(tags["owner"], tags["thing"], tags["node"])
+	if err != nil {
+		servErr.Internal = true
+		servErr.Err = err
+	} else if len(attributes) == 0 {
+		servErr.Not_Found = true
+		servErr.Err = fmt.Errorf("No static attributes were saved for %s", tags)
+	}
+	return
+}
+
+//ReplaceAttributes replaces every static attribute of a node
+func (s *StaticService) ReplaceAttributes(tags map[string]string, attributes map[string]interface{}) (map[string]interface{}, utils.ServiceError) {
+	return s.update(tags, attributes, func(saved map[string]interface{}) error {
+		for name := range saved {
+			delete(saved, name)
+		}
+		for name, value := range attributes {
+			if value == nil {
+				return fmt.Errorf("The static attribute `%s` can't be null", name)
+			}
+			saved[name] = value
+		}
+		return nil
+	})
+}
+
+//MergeAttributes adds or changes the given static attributes of a node, removing the null ones
+func (s *StaticService) MergeAttributes(tags map[string]string, attributes map[string]interface{}) (map[string]interface{}, utils.ServiceError) {
+	return s.update(tags, attributes, func(saved map[string]interface{}) error {
+		for name, value := range attributes {
+			if value == nil {
+				delete(saved, name)
+			} else {
+				saved[name] = value
+			}
+		}
+		return nil
+	})
+}
+
+//DeleteAttributes removes every static attribute of a node
+func (s *StaticService) DeleteAttributes(tags map[string]string) (servErr utils.ServiceError) {
+	if err := validateStaticTags(tags); err != nil {
+		servErr.Invalid = true
+		servErr.Err = err
+		return
+	}
+
+	if err := s.repo.DeleteAttributes(tags["owner"], tags["thing"], tags["node"]); err != nil {
+		servErr.Internal = true
+		servErr.Err = err
+	}
+	return
+}
+
+// Join sets the static attributes of the node of each point, reading each node once
+func (s *StaticService) Join(points []models.StatePoint) error {
+	cache := map[[3]string]map[string]interface{}{}
+	for i := range points {
+		key := [3]string{points[i].Owner, points[i].Thing, points[i].Node}
+		attributes, found := cache[key]
+		if !found {
+			var err error
+			if attributes, err = s.repo.GetAttributes(key[0], key[1], key[2]); err != nil {
+				return err
+			}
+			cache[key] = attributes
+		}
+		if len(attributes) > 0 {
+			points[i].Static = attributes
+		}
+	}
+	return nil
+}
+
+// Close closes the static database
+func (s *StaticService) Close() error {
+	return s.repo.Close()
+}
+
+func (s *StaticService) update(tags map[string]string, attributes map[string]interface{}, update func(saved map[string]interface{}) error) (saved map[string]interface{}, servErr utils.ServiceError) {
+	err := validateStaticTags(tags)
+	if err == nil {
+		err = ValidateStaticAttributes(attributes)
+	}
+	if err != nil {
+		servErr.Invalid = true
+		servErr.Err = err
+		return
+	}
+
+	var invalid error
+	saved, err = s.repo.UpdateAttributes(tags["owner"], tags["thing"], tags["node"], func(saved map[string]interface{}) error {
+		invalid = update(saved)
+		return invalid
+	})
+	if invalid != nil {
+		servErr.Invalid = true
+		servErr.Err = invalid
+	} else if err != nil {
+		servErr.Internal = true
+		servErr.Err = err
+	}
+	return
+}
+
+// ValidateStaticAttributes requires the names to be prefixed with `$` and the values to be
+// strings, numbers, booleans or null
+func ValidateStaticAttributes(attributes map[string]interface{}) error {
+	if len(attributes) == 0 {
+		return fmt.Errorf("At least one static attribute must be provided")
+	}
+
+	for name, value := range attributes {
+		if !strings.HasPrefix(name, "$") || len(name) == 1 {
+			return fmt.Errorf("Static attributes must be prefixed with `$`, like: $name, $unit and so on. Invalid attribute: %s", name)
+		}
+		switch value.(type) {
+		case nil, string, float64, bool:
+		default:
+			return fmt.Errorf("The static attribute `%s` must be a string, a number or a boolean", name)
+		}
+	}
+	return nil
+}
+
+// validateStaticTags requires a single node, without wildcards
+func validateStaticTags(tags map[string]string) error {
+	for _, tag := range []string{"owner", "thing", "node"} {
+		if tags[tag] == "" || tags[tag] == "+" {
+			return fmt.Errorf("The `tags` 'owner', 'thing' and 'node' must be provided, without wildcards, to handle static attributes. Tags provided: %s", tags)
+		}
+	}
+	return nil
+}
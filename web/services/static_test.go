@@ -0,0 +1,20 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestValidateStaticAttributes(t *testing.T) {
+	assert.NilError(t, ValidateStaticAttributes(map[string]interface{}{"$name": "truck 42", "$capacity": 12.5, "$refrigerated": true, "$old": nil}))
+
+	assert.Error(t, ValidateStaticAttributes(map[string]interface{}{}), "At least one")
+	assert.Error(t, ValidateStaticAttributes(map[string]interface{}{"name": "truck 42"}), "must be prefixed with `$`")
+	assert.Error(t, ValidateStaticAttributes(map[string]interface{}{"$": "truck 42"}), "must be prefixed with `$`")
+	assert.Error(t, ValidateStaticAttributes(map[string]interface{}{"$size": map[string]interface{}{"w": 2}}), "must be a string, a number or a boolean")
+
+	_, servErr := new(StaticService).MergeAttributes(map[string]string{"owner": "movbb", "thing": "+", "node": "location"}, map[string]interface{}{"$name": "x"})
+	assert.Equal(t, servErr.Invalid, true)
+	assert.Error(t, servErr.Err, "without wildcards")
+}
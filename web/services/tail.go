@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+)
+
+// Subscription receives the points matching its tags, the `+` wildcard matching any value.
+// The points are buffered, and the ones that don't fit the buffer are dropped and counted
+type Subscription struct {
+	C       <-chan models.StatePoint
+	points  chan models.StatePoint
+	tags    map[string]string
+	dropped int64
+}
+
+// Dropped returns the number of points dropped since the last call
+func (sub *Subscription) Dropped() int64 {
+	return atomic.SwapInt64(&sub.dropped, 0)
+}
+
+func (sub *Subscription) matches(point models.StatePoint) bool {
+	values := map[string]string{"owner": point.Owner, "thing": point.Thing, "node": point.Node}
+	for tag, value := range sub.tags {
+		if value != "+" && value != values[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+// TailHub fans the points being written out to the live subscribers. Publishing never waits
+// for a subscriber, so slow ones can't hold the ingestion back
+type TailHub struct {
+	lock          sync.RWMutex
+	buffer        int
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+// NewTailHub creates a hub whose subscribers buffer up to `buffer` points
+func NewTailHub(buffer int) *TailHub {
+	if buffer <= 0 {
+		buffer = 1
+	}
+	return &TailHub{buffer: buffer, subscriptions: map[*Subscription]bool{}}
+}
+
+// Subscribe starts receiving the points matching the owner, thing and node tags
+func (h *TailHub) Subscribe(tags map[string]string) (*Subscription, error) {
+	if err := validateQueryTags(tags); err != nil {
+		return nil, err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return nil, fmt.Errorf("The service is shutting down")
+	}
+
+	points := make(chan models.StatePoint, h.buffer)
+	sub := &Subscription{C: points, points: points, tags: tags}
+	h.subscriptions[sub] = true
+	return sub, nil
+}
+
+// Unsubscribe stops the subscription and closes its channel
+func (h *TailHub) Unsubscribe(sub *Subscription) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.subscriptions[sub] {
+		delete(h.subscriptions, sub)
+		close(sub.points)
+	}
+}
+
+// HasSubscribers tells whether there is anyone to publish to
+func (h *TailHub) HasSubscribers() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.subscriptions) > 0
+}
+
+// Publish sends the point to the matching subscribers whose buffer isn't full
+func (h *TailHub) Publish(point models.StatePoint) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for sub := range h.subscriptions {
+		if !sub.matches(point) {
+			continue
+		}
+		select {
+		case sub.points <- point:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Close ends every subscription
+func (h *TailHub) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.closed = true
+	for sub := range h.subscriptions {
+		delete(h.subscriptions, sub)
+		close(sub.points)
+	}
+}
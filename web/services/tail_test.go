@@ -0,0 +1,56 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/labbsr0x/kafka2influxdb/database/models"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+func TestTailHubFiltersByTags(t *testing.T) {
+	hub := NewTailHub(10)
+	all, err := hub.Subscribe(map[string]string{"owner": "movbb", "thing": "+", "node": "location"})
+	assert.NilError(t, err)
+	one, err := hub.Subscribe(map[string]string{"owner": "movbb", "thing": "abc1234", "node": "location"})
+	assert.NilError(t, err)
+
+	hub.Publish(models.StatePoint{Owner: "movbb", Thing: "abc1234", Node: "location"})
+	hub.Publish(models.StatePoint{Owner: "movbb", Thing: "def5678", Node: "location"})
+	hub.Publish(models.StatePoint{Owner: "movbb", Thing: "def5678", Node: "battery"})
+
+	assert.Equal(t, len(all.C), 2)
+	assert.Equal(t, len(one.C), 1)
+	assert.Equal(t, (<-one.C).Thing, "abc1234")
+
+	_, err = hub.Subscribe(map[string]string{"owner": "+", "thing": "+", "node": "+"})
+	assert.Error(t, err, "must be provided")
+}
+
+func TestTailHubDropsWhatSlowSubscribersCantTake(t *testing.T) {
+	hub := NewTailHub(2)
+	sub, err := hub.Subscribe(map[string]string{"owner": "movbb", "thing": "+", "node": "+"})
+	assert.NilError(t, err)
+
+	// publishing never blocks, even when nobody reads
+	for i := 0; i < 5; i++ {
+		hub.Publish(models.StatePoint{Owner: "movbb", Thing: "abc1234", Node: "location"})
+	}
+	assert.Equal(t, len(sub.C), 2)
+	assert.Equal(t, sub.Dropped(), int64(3))
+	assert.Equal(t, sub.Dropped(), int64(0))
+
+	hub.Unsubscribe(sub)
+	assert.Equal(t, hub.HasSubscribers(), false)
+	hub.Unsubscribe(sub)
+
+	other, err := hub.Subscribe(map[string]string{"owner": "movbb", "thing": "+", "node": "+"})
+	assert.NilError(t, err)
+	hub.Close()
+	_, open := <-other.C
+	assert.Equal(t, open, false)
+	hub.Publish(models.StatePoint{Owner: "movbb"})
+
+	_, err = hub.Subscribe(map[string]string{"owner": "movbb"})
+	assert.Error(t, err, "shutting down")
+}
@@ -2,81 +2,207 @@ package utils
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// PeriodError tells which query param could not be parsed into a valid period and why
+type PeriodError struct {
+	Param  string
+	Value  string
+	Reason string
+}
+
+func (e *PeriodError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("Invalid `%s` query param: %s", e.Param, e.Reason)
+	}
+	return fmt.Sprintf("Invalid `%s` query param %q: %s", e.Param, e.Value, e.Reason)
+}
+
+// instantFormats lists the accepted instant expressions, used in the error messages
+const instantFormats = "use a RFC3339 date time (Ex: 2019-06-02T00:00:00Z), `now` or a duration relative to now (Ex: -24h, now-7d)"
+
+// relativePattern matches `now`, optionally followed by an offset, or an offset alone
+var relativePattern = regexp.MustCompile(`^(now)?(?:([+-])([0-9a-z.]+))?$`)
+
+// isoDurationPattern matches ISO-8601 durations (Ex: P1D, PT6H, P1Y2M10DT2H30M, P2W)
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// PeriodParser reads the time range of a query. Now is the anchor of relative expressions,
+// and MaxPeriod, when positive, rejects longer or open-ended periods
+type PeriodParser struct {
+	Now       func() time.Time
+	MaxPeriod time.Duration
+}
+
+// ParsePeriodDateTime reads a time range without limiting its length
 func ParsePeriodDateTime(timeQuery string, startDateTimeQuery string, endDateTimeQuery string) (time.Time, time.Time, error) {
-	// There are 2 types of query params that can be used:
-	// 1 - time: using the patterns: ?time=2019-01-02T00:00:00Z/2019-06-02T00:00:00Z | ?time=2019-01-02T00:00:00Z/ | ?time=/2019-06-02T00:00:00Z
-	// 2 - startDateTime and endDateTime: ?startDateTime=2019-01-02T00:00:00Z&endDateTime=2019-06-02T00:00:00Z | ?startDateTime=2019-01-02T00:00:00Z | ?endDateTime=2019-06-02T00:00:00Z
-	var startTime time.Time
-	var endTime time.Time
-	var err error
-
-	timeQueryParamFormatErrorMessage := "The query param `time` must be in the one of the following patterns [ /" + time.RFC3339 + " | " + time.RFC3339 + "/ | " + time.RFC3339 + "/" + time.RFC3339 + " ] (Ex: \"/2019-06-02T00:00:00Z\", \"2019-06-02T00:00:00Z/\", \"2019-02-01T00:00:00/2019-06-02T00:00:00Z\")"
-	if timeQuery != "" { // if the query param `time` was provided
-		if strings.Contains(timeQuery, "/") {
-			parts := strings.Split(timeQuery, "/")
-			if len(parts) == 2 && parts[1] == "" { // pattern: "2019-06-02T00:00:00/Z"
-				startTime, err = time.Parse(time.RFC3339, parts[0])
-				endTime = time.Date(2200, time.January, 1, 0, 0, 0, 0, time.UTC)
-
-			} else if len(parts) == 2 && parts[0] == "" { // pattern: "/2019-06-02T00:00:00Z"
-				startTime = time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)
-				endTime, err = time.Parse(time.RFC3339, parts[1])
-				if err != nil {
-					fmt.Printf("%s", err)
-					return time.Time{}, time.Time{}, fmt.Errorf(timeQueryParamFormatErrorMessage)
-				}
-
-			} else if len(parts) == 2 && parts[0] != "" && parts[1] != "" { // pattern: "2019-02-02T00:00:00/2019-06-02T00:00:00Z"
-				startTime, err = time.Parse(time.RFC3339, parts[0])
-				if err != nil {
-					fmt.Printf("%s", err)
-					return time.Time{}, time.Time{}, fmt.Errorf(timeQueryParamFormatErrorMessage)
-				}
-
-				endTime, err = time.Parse(time.RFC3339, parts[1])
-				if err != nil {
-					fmt.Printf("%s", err)
-					return time.Time{}, time.Time{}, fmt.Errorf(timeQueryParamFormatErrorMessage)
-				}
-			} else {
-				fmt.Printf("%s", err)
-				return time.Time{}, time.Time{}, fmt.Errorf(timeQueryParamFormatErrorMessage)
-			}
-		} else { // if the `/` was not provided
-			fmt.Printf("%s", err)
-			return time.Time{}, time.Time{}, fmt.Errorf(timeQueryParamFormatErrorMessage)
-		}
+	return new(PeriodParser).Parse(timeQuery, startDateTimeQuery, endDateTimeQuery)
+}
 
-	} else { // if startDateTime and/or endDateTime was provided
+// Parse reads a time range. There are 2 types of query params that can be used:
+//
+//	1 - time: an interval made of two instants or one instant and an ISO-8601 duration, any of
+//	    the instants being optional: ?time=2019-01-02T00:00:00Z/2019-06-02T00:00:00Z | ?time=-24h/now |
+//	    ?time=P1D/2020-05-01T00:00:00Z | ?time=2020-05-01T00:00:00Z/PT6H | ?time=2019-01-02T00:00:00Z/
+//	2 - startDateTime and endDateTime: ?startDateTime=2019-01-02T00:00:00Z&endDateTime=now | ?startDateTime=-7d
+//
+// A missing instant leaves its end of the period open, returned as the zero time
+func (p *PeriodParser) Parse(timeQuery string, startDateTimeQuery string, endDateTimeQuery string) (start time.Time, end time.Time, err error) {
+	now := time.Now()
+	if p.Now != nil {
+		now = p.Now()
+	}
 
-		if startDateTimeQuery == "" && endDateTimeQuery == "" {
-			fmt.Printf("%s", err)
-			return time.Time{}, time.Time{}, fmt.Errorf("You must provide either `time` query param or `startDateTime` and `endDateTime` query params to filter required data")
+	if timeQuery != "" {
+		start, end, err = parseInterval(timeQuery, now)
+	} else if startDateTimeQuery == "" && endDateTimeQuery == "" {
+		return time.Time{}, time.Time{}, &PeriodError{Param: "time", Reason: "provide either the `time` query param or the `startDateTime` and `endDateTime` query params to filter the required data"}
+	} else {
+		if start, err = parseInstant("startDateTime", startDateTimeQuery, now); err == nil {
+			end, err = parseInstant("endDateTime", endDateTimeQuery, now)
 		}
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
 
-		if startDateTimeQuery != "" {
-			startTime, err = time.Parse(time.RFC3339, startDateTimeQuery)
-			if err != nil {
-				fmt.Printf("%s", err)
-				return time.Time{}, time.Time{}, fmt.Errorf("The `startDateTime` query param must be in RFC3339 pattern, e.g. " + time.RFC3339)
-			}
+	if err = p.validate(start, end); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, end, nil
+}
+
+// validate rejects reversed periods and the ones longer than MaxPeriod
+func (p *PeriodParser) validate(start time.Time, end time.Time) error {
+	if !start.IsZero() && !end.IsZero() && start.After(end) {
+		return &PeriodError{Param: "time", Reason: fmt.Sprintf("the start %s is after the end %s", start.Format(time.RFC3339), end.Format(time.RFC3339))}
+	}
+
+	if p.MaxPeriod <= 0 {
+		return nil
+	}
+	if start.IsZero() || end.IsZero() {
+		return &PeriodError{Param: "time", Reason: fmt.Sprintf("open-ended periods are not allowed, the max period is %s", p.MaxPeriod)}
+	}
+	if span := end.Sub(start); span > p.MaxPeriod {
+		return &PeriodError{Param: "time", Reason: fmt.Sprintf("the period spans %s, more than the max of %s", span, p.MaxPeriod)}
+	}
+	return nil
+}
+
+// parseInterval reads a `start/end` interval where one of the sides may be an ISO-8601 duration
+func parseInterval(interval string, now time.Time) (start time.Time, end time.Time, err error) {
+	parts := strings.Split(interval, "/")
+	if len(parts) != 2 || (parts[0] == "" && parts[1] == "") {
+		return time.Time{}, time.Time{}, &PeriodError{Param: "time", Value: interval, Reason: "use `start/end`, where either side is optional or an ISO-8601 duration (Ex: 2019-01-02T00:00:00Z/2019-06-02T00:00:00Z, -24h/now, P1D/2020-05-01T00:00:00Z, 2020-05-01T00:00:00Z/PT6H, 2019-01-02T00:00:00Z/)"}
+	}
+
+	startDuration, startIsDuration := parseISODuration(parts[0])
+	endDuration, endIsDuration := parseISODuration(parts[1])
+
+	switch {
+	case startIsDuration && endIsDuration:
+		return time.Time{}, time.Time{}, &PeriodError{Param: "time", Value: interval, Reason: "only one side of the interval can be a duration"}
+	case startIsDuration:
+		if parts[1] == "" {
+			return time.Time{}, time.Time{}, &PeriodError{Param: "time", Value: interval, Reason: "a duration requires the other side of the interval"}
+		}
+		if end, err = parseInstant("time", parts[1], now); err != nil {
+			return
+		}
+		return startDuration.addTo(end, -1), end, nil
+	case endIsDuration:
+		if parts[0] == "" {
+			return time.Time{}, time.Time{}, &PeriodError{Param: "time", Value: interval, Reason: "a duration requires the other side of the interval"}
+		}
+		if start, err = parseInstant("time", parts[0], now); err != nil {
+			return
 		}
-		if endDateTimeQuery != "" {
-			endTime, err = time.Parse(time.RFC3339, endDateTimeQuery)
+		return start, endDuration.addTo(start, 1), nil
+	}
+
+	if start, err = parseInstant("time", parts[0], now); err != nil {
+		return
+	}
+	end, err = parseInstant("time", parts[1], now)
+	return
+}
+
+// parseInstant reads a RFC3339 date time, `now` or a duration relative to now. An empty
+// value is the zero time
+func parseInstant(param string, value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	match := relativePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(value)))
+	if match == nil || (match[1] == "" && match[2] == "") {
+		return time.Time{}, &PeriodError{Param: param, Value: value, Reason: instantFormats}
+	}
+	if match[2] == "" {
+		return now, nil
+	}
+
+	offset, err := parseDuration(match[3])
+	if err != nil {
+		return time.Time{}, &PeriodError{Param: param, Value: value, Reason: instantFormats}
+	}
+	if match[2] == "-" {
+		offset = -offset
+	}
+	return now.Add(offset), nil
+}
+
+// parseDuration extends time.ParseDuration with days (d) and weeks (w)
+func parseDuration(value string) (time.Duration, error) {
+	for unit, length := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, unit) {
+			n, err := strconv.Atoi(strings.TrimSuffix(value, unit))
 			if err != nil {
-				fmt.Printf("%s", err)
-				return time.Time{}, time.Time{}, fmt.Errorf("The `endDateTime` query param must be in RFC3339 pattern, e.g. " + time.RFC3339)
+				return 0, err
 			}
+			return time.Duration(n) * length, nil
 		}
 	}
-	if err != nil {
-		fmt.Printf("%s", err)
-		return time.Time{}, time.Time{}, fmt.Errorf("Error parsing request body. Err: %s", err)
+	return time.ParseDuration(value)
+}
+
+// isoDuration holds the calendar and clock parts of an ISO-8601 duration
+type isoDuration struct {
+	years, months, days int
+	clock               time.Duration
+}
+
+// parseISODuration reads an ISO-8601 duration, telling whether the value is one
+func parseISODuration(value string) (isoDuration, bool) {
+	match := isoDurationPattern.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return isoDuration{}, false
 	}
 
-	return startTime, endTime, nil
+	number := func(i int) int {
+		n, _ := strconv.Atoi(match[i])
+		return n
+	}
+	seconds, _ := strconv.ParseFloat(match[7], 64)
+
+	return isoDuration{
+		years:  number(1),
+		months: number(2),
+		days:   number(3)*7 + number(4),
+		clock:  time.Duration(number(5))*time.Hour + time.Duration(number(6))*time.Minute + time.Duration(seconds*float64(time.Second)),
+	}, true
+}
+
+// addTo moves a time forward (sign 1) or backwards (sign -1) by the duration
+func (d isoDuration) addTo(t time.Time, sign int) time.Time {
+	return t.AddDate(sign*d.years, sign*d.months, sign*d.days).Add(time.Duration(sign) * d.clock)
 }
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/pkg/testutil/assert"
+)
+
+var now = time.Date(2020, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestParser(max time.Duration) *PeriodParser {
+	return &PeriodParser{Now: func() time.Time { return now }, MaxPeriod: max}
+}
+
+func TestParseAbsolutePeriod(t *testing.T) {
+	start, end, err := newTestParser(0).Parse("2019-01-02T00:00:00Z/2019-06-02T00:00:00Z", "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, start, time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, end, time.Date(2019, time.June, 2, 0, 0, 0, 0, time.UTC))
+
+	start, end, err = newTestParser(0).Parse("2019-01-02T00:00:00Z/", "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, start, time.Date(2019, time.January, 2, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, end.IsZero(), true)
+
+	start, end, err = newTestParser(0).Parse("", "", "2019-06-02T00:00:00Z")
+	assert.NilError(t, err)
+	assert.Equal(t, start.IsZero(), true)
+	assert.Equal(t, end, time.Date(2019, time.June, 2, 0, 0, 0, 0, time.UTC))
+}
+
+func TestParseRelativePeriod(t *testing.T) {
+	start, end, err := newTestParser(0).Parse("-24h/now", "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, start, now.Add(-24*time.Hour))
+	assert.Equal(t, end, now)
+
+	start, end, err = newTestParser(0).Parse("", "now-7d", "now-1h30m")
+	assert.NilError(t, err)
+	assert.Equal(t, start, now.AddDate(0, 0, -7))
+	assert.Equal(t, end, now.Add(-90*time.Minute))
+}
+
+func TestParseISODurationPeriod(t *testing.T) {
+	start, end, err := newTestParser(0).Parse("P1D/2020-05-01T00:00:00Z", "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, start, time.Date(2020, time.April, 30, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, end, time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC))
+
+	start, end, err = newTestParser(0).Parse("2020-05-01T00:00:00Z/PT6H", "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, end, start.Add(6*time.Hour))
+
+	start, end, err = newTestParser(0).Parse("P1M2W/now", "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, start, time.Date(2020, time.March, 18, 12, 0, 0, 0, time.UTC))
+	assert.Equal(t, end, now)
+
+	start, _, err = newTestParser(0).Parse("PT1.5S/now", "", "")
+	assert.NilError(t, err)
+	assert.Equal(t, start, now.Add(-1500*time.Millisecond))
+}
+
+func TestParseInvalidPeriod(t *testing.T) {
+	cases := map[string][3]string{
+		"use `start/end`":               {"2019-01-02T00:00:00Z", "", ""},
+		"only one side":                 {"P1D/PT6H", "", ""},
+		"requires the other side":       {"P1D/", "", ""},
+		"`now` or a duration":           {"yesterday/now", "", ""},
+		"is after the end":              {"now/-1h", "", ""},
+		"`startDateTime` query param":   {"", "2019-01-02", ""},
+		"provide either the `time`":     {"", "", ""},
+		"Invalid `time` query param \"": {"P/now", "", ""},
+	}
+	for reason, params := range cases {
+		_, _, err := newTestParser(0).Parse(params[0], params[1], params[2])
+		assert.Error(t, err, reason)
+		_, ok := err.(*PeriodError)
+		assert.Equal(t, ok, true)
+	}
+}
+
+func TestParseLimitsThePeriod(t *testing.T) {
+	parser := newTestParser(7 * 24 * time.Hour)
+
+	_, _, err := parser.Parse("P7D/now", "", "")
+	assert.NilError(t, err)
+
+	_, _, err = parser.Parse("P8D/now", "", "")
+	assert.Error(t, err, "more than the max of 168h0m0s")
+
+	_, _, err = parser.Parse("-1h/", "", "")
+	assert.Error(t, err, "open-ended periods are not allowed")
+}
@@ -10,6 +10,7 @@ type ServiceError struct {
 	Internal  bool
 	Forbidden bool
 	Invalid   bool
+	Err       error
 }
 
 func (r *ServiceError) SetStatusCode() int {
@@ -1,13 +1,21 @@
 package web
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/labbsr0x/kafka2influxdb/database"
 	"github.com/labbsr0x/kafka2influxdb/web/config"
 	"github.com/labbsr0x/kafka2influxdb/web/controllers"
+	"github.com/labbsr0x/kafka2influxdb/web/metrics"
+	"github.com/labbsr0x/kafka2influxdb/web/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,6 +23,8 @@ type Server struct {
 	*config.WebBuilder
 	app      *gin.Engine
 	consumer *controllers.ConsumerController
+	static   *controllers.StaticController
+	health   *controllers.HealthController
 	kafka    *database.DefaultKafka
 }
 
@@ -22,8 +32,12 @@ type Server struct {
 func (s *Server) InitFromWebBuilder(webBuilder *config.WebBuilder) *Server {
 	s.WebBuilder = webBuilder
 	s.app = gin.Default()
-	s.consumer = controllers.NewConsumerController(s.WebBuilder)
+	s.app.Use(metrics.Middleware())
+	staticService := services.NewStaticService(s.WebBuilder)
+	s.static = controllers.NewStaticController(staticService)
+	s.consumer = controllers.NewConsumerController(s.WebBuilder).JoinStatic(staticService)
 	s.kafka = database.NewKafka(s.WebBuilder).Connect()
+	s.health = controllers.NewHealthController(s.readinessChecks())
 
 	logLevel, err := logrus.ParseLevel(s.WebBuilder.LogLevel)
 	if err != nil {
@@ -35,18 +49,118 @@ func (s *Server) InitFromWebBuilder(webBuilder *config.WebBuilder) *Server {
 	return s
 }
 
-func (s *Server) Run() {
+// readinessChecks checks the dependencies the service can't work without
+func (s *Server) readinessChecks() *services.HealthService {
+	timeout := s.WebBuilder.HealthCheckTimeout
+	health := services.NewHealthService(timeout)
+	health.Register("influxdb", func() error { return s.consumer.PingDatabase(timeout) })
+	health.Register("schemaRegistry", func() error { return s.consumer.PingSchemaRegistry(timeout) })
+	health.Register("kafka", s.kafka.Ping)
+	health.Register("consumer", func() error {
+		if !s.kafka.Running() {
+			return fmt.Errorf("The Kafka consumer stopped")
+		}
+		return nil
+	})
+	return health
+}
+
+// Run serves the REST APIs and consumes the Kafka topics until SIGTERM or SIGINT. It fails when
+// the consumer gives up and KafkaExitOnFailure is set, otherwise only the readiness check fails
+func (s *Server) Run() error {
 	logrus.Info("Version 0.0.1")
 	consumerGroup := s.app.Group("/")
 	{
 		consumerGroup.GET("/", index)
+		consumerGroup.GET("/healthz", s.health.LiveHandler)
+		consumerGroup.GET("/readyz", s.health.ReadyHandler)
+		consumerGroup.GET("/metrics", gin.WrapH(promhttp.Handler()))
 		consumerGroup.GET("/owner/:owner/thing/:thing/node/:node", s.consumer.GetHandler)
+		consumerGroup.GET("/owner/:owner/thing/:thing/node/:node/latest", s.consumer.LatestHandler)
+		consumerGroup.GET("/owner/:owner/thing/:thing/node/:node/export", s.consumer.ExportHandler)
+		consumerGroup.GET("/owner/:owner/thing/:thing/node/:node/stream", s.consumer.StreamHandler)
 		consumerGroup.POST("/owner/:owner/thing/:thing/node/:node", s.consumer.CreateHandler)
+		consumerGroup.DELETE("/owner/:owner/thing/:thing/node/:node", s.consumer.DeleteHandler)
+		consumerGroup.POST("/bulk", s.consumer.BulkHandler)
+		consumerGroup.POST("/write", s.consumer.WriteHandler)
+		consumerGroup.GET("/owner/:owner/thing/:thing/node/:node/static", s.static.GetHandler)
+		consumerGroup.PUT("/owner/:owner/thing/:thing/node/:node/static", s.static.PutHandler)
+		consumerGroup.PATCH("/owner/:owner/thing/:thing/node/:node/static", s.static.PatchHandler)
+		consumerGroup.DELETE("/owner/:owner/thing/:thing/node/:node/static", s.static.DeleteHandler)
+	}
+
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	listened := make(chan struct{})
+	failed := make(chan error, 1)
+	go func() {
+		defer close(listened)
+		if err := s.kafka.ListenGroup(listenCtx, s.consumer.ListenHandler); err != nil {
+			logrus.Errorf("The Kafka consumer gave up: %v", err)
+			if s.WebBuilder.KafkaExitOnFailure {
+				failed <- err
+			}
+		}
+	}()
+
+	server := &http.Server{Addr: "0.0.0.0:" + s.WebBuilder.Port, Handler: s.app}
+	server.RegisterOnShutdown(s.consumer.CloseStreams)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("Error serving HTTP: %v", err)
+			panic(fmt.Sprintf("Error serving HTTP: %v", err))
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var err error
+	select {
+	case sig := <-signals:
+		logrus.Infof("Received %s. Shutting down in up to %s", sig, s.WebBuilder.ShutdownGracePeriod)
+	case err = <-failed:
+		logrus.Errorf("Shutting down in up to %s, as the Kafka consumer gave up", s.WebBuilder.ShutdownGracePeriod)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.WebBuilder.ShutdownGracePeriod)
+	defer cancel()
+	s.shutdown(ctx, stopListening, listened, server)
+	return err
+}
+
+// shutdown stops fetching messages and waits for the fetched ones to be written and committed,
+// then finishes the HTTP requests and closes the clients. Whatever doesn't finish before the
+// context is done is abandoned
+func (s *Server) shutdown(ctx context.Context, stopListening context.CancelFunc, listened <-chan struct{}, server *http.Server) {
+	stopListening()
+	select {
+	case <-listened:
+		logrus.Info("Kafka consumer stopped")
+	case <-ctx.Done():
+		logrus.Warn("The Kafka consumer didn't stop in time. Uncommitted messages will be consumed again")
 	}
 
-	go s.kafka.ListenGroup(s.consumer.ListenHandler)
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Errorf("Error shutting the HTTP server down: %v", err)
+	}
 
-	s.app.Run("0.0.0.0:" + s.WebBuilder.Port)
+	// the points queued by the consumer and the HTTP requests are flushed before closing
+	closed := make(chan error, 1)
+	go func() {
+		closed <- s.consumer.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			logrus.Errorf("Error closing the database: %v", err)
+		}
+	case <-ctx.Done():
+		logrus.Warn("The pending points weren't written in time and were lost")
+	}
+
+	if err := s.static.Close(); err != nil {
+		logrus.Errorf("Error closing the static database: %v", err)
+	}
+	logrus.Info("Shutdown complete")
 }
 
 func index(ctx *gin.Context) {